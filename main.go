@@ -1,102 +1,710 @@
 package main
 
 import (
+	"encoding/json"
 	"github.com/Financial-Times/up-restutil/restutil"
 	log "github.com/Sirupsen/logrus"
 	"github.com/jawher/mow.cli"
+	"golang.org/x/net/context"
 	"golang.org/x/net/proxy"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
 
 	app := cli.App("up-restutil", "A RESTful resource utility")
 
-	socksProxy := app.StringOpt("socks-proxy", "", "Use specified SOCKS proxy (e.g. localhost:2323)")
+	socksProxy = app.StringOpt("socks-proxy", "", "Use specified SOCKS proxy (e.g. localhost:2323)")
+	metricsAddr := app.StringOpt("metrics-addr", "", "address to serve Prometheus metrics on at /metrics while the command runs (e.g. :8080)")
+	summaryFile = app.StringOpt("summary", "", "file to write the JSON summary of the command to, instead of stderr")
+	netrcFile = app.String(cli.StringOpt{
+		Name:   "netrc",
+		Desc:   "netrc file to read basic auth credentials from, by host, when none are given for an endpoint",
+		EnvVar: "UP_RESTUTIL_NETRC",
+	})
+
+	drainTimeout = app.IntOpt("drain-timeout", 20, "seconds to wait for the requests in flight to complete on SIGINT or SIGTERM, before cancelling them")
+
+	ctx := interruptContext()
+	app.Before = func() {
+		if *metricsAddr != "" {
+			serveMetrics(*metricsAddr)
+		}
+	}
 
 	app.Command("put-resources", "Read JSON resources from stdin and PUT them to an endpoint", func(cmd *cli.Cmd) {
-		user := cmd.StringOpt("user", "", "user for basic auth")
-		pass := cmd.StringOpt("pass", "", "password for basic auth")
+		cmd.Spec = "[OPTIONS] (--load | IDPROP) BASEURL"
+		load := loadOpt(cmd)
+		auth := authOpts(cmd, "", "")
 		dumpFailed := cmd.BoolOpt("dump-failed", false, "dump failed resources to stdout, instead of exiting on failure")
 		concurrency := cmd.IntOpt("concurrency", 16, "number of concurrent requests to use")
+		throttle := throttleOpts(cmd, "", 0, "number of PUT requests to make a second (0 for no limit)")
 		idProp := cmd.StringArg("IDPROP", "", "property name of identity property")
 		baseURL := cmd.StringArg("BASEURL", "", "base URL to PUT resources to")
+		retry := retryOpts(cmd)
+		failures := failureOpts(cmd)
 		cmd.Action = func() {
-			if *socksProxy != "" {
-				dialer, _ := proxy.SOCKS5("tcp", *socksProxy, nil, proxy.Direct)
-				restutil.Transport.Dial = dialer.Dial
-			}
-			if err := restutil.PutAllRest(*baseURL, *idProp, *user, *pass, *concurrency, *dumpFailed); err != nil {
-				log.Fatal(err)
+			client := newClient(retry)
+			auth.register(client, *baseURL)
+			if *load != "" {
+				summary, err := client.LoadArchive(ctx, *load, *baseURL, *auth.user, *auth.pass, *concurrency, throttle.limiter(), failures.open())
+				failures.close()
+				finish(summary, err)
+				return
 			}
+			summary, err := client.PutAllRest(ctx, *baseURL, *idProp, *auth.user, *auth.pass, *concurrency, throttle.limiter(), stdoutIf(*dumpFailed), failures.open(), os.Stdin)
+			failures.close()
+			finish(summary, err)
 		}
 
 	})
 
 	app.Command("put-binary-resources", "Read IDS from one endpoint and PUT them to another endpoint", func(cmd *cli.Cmd) {
-		user := cmd.StringOpt("user", "", "user for basic auth")
-		pass := cmd.StringOpt("pass", "", "password for basic auth")
+		cmd.Spec = "[OPTIONS] (--load | FROM_BASEURL) TO_BASEURL"
+		load := loadOpt(cmd)
+		sourceAuth := authOpts(cmd, "source-", " when reading from the source")
+		sourceIDs := idListOpts(cmd, "source-", " of the source")
+		auth := authOpts(cmd, "", "")
 		dumpFailed := cmd.BoolOpt("dump-failed", false, "dump failed resources to stdout, instead of exiting on failure")
 		concurrency := cmd.IntOpt("concurrency", 16, "number of concurrent requests to use")
-		throttle := cmd.IntOpt("throttle", 0, "number of PUT requests to make a second")
+		throttle := throttleOpts(cmd, "", 0, "number of PUT requests to make a second")
 		fromBaseURL := cmd.StringArg("FROM_BASEURL", "", "base URL to PUT resources to")
 		toBaseURL := cmd.StringArg("TO_BASEURL", "", "base URL to PUT resources to")
+		retry := retryOpts(cmd)
+		failures := failureOpts(cmd)
 		cmd.Action = func() {
-			if *socksProxy != "" {
-				dialer, _ := proxy.SOCKS5("tcp", *socksProxy, nil, proxy.Direct)
-				restutil.Transport.Dial = dialer.Dial
-			}
-			if err := restutil.PutAllBinaryRest(*fromBaseURL, *toBaseURL, *user, *pass, *concurrency, *throttle, *dumpFailed); err != nil {
-				log.Fatal(err)
+			client := newClient(retry)
+			auth.register(client, *toBaseURL)
+			if *load != "" {
+				summary, err := client.LoadArchive(ctx, *load, *toBaseURL, *auth.user, *auth.pass, *concurrency, throttle.limiter(), failures.open())
+				failures.close()
+				finish(summary, err)
+				return
 			}
+			sourceAuth.register(client, *fromBaseURL)
+			sourceIDs.register(client, *fromBaseURL)
+			summary, err := client.PutAllBinaryRest(ctx, *fromBaseURL, *toBaseURL, *auth.user, *auth.pass, *concurrency, throttle.limiter(), stdoutIf(*dumpFailed), failures.open())
+			failures.close()
+			finish(summary, err)
 		}
 	})
 
-	app.Command("dump-resources", "Read JSON resources from an endpoint and dump them to stdout", func(cmd *cli.Cmd) {
+	app.Command("dump-resources", "Read JSON resources from an endpoint and dump them to stdout as JSON Lines", func(cmd *cli.Cmd) {
+		output := cmd.StringOpt("output", "", "file to dump the resources to, instead of stdout, compressed with gzip or zstd when it ends in .gz or .zst")
+		compress := cmd.StringOpt("compress", "", "compress the resources dumped to stdout with gzip or zstd")
+		archive := cmd.StringOpt("archive", "", "directory, or .tar, .tar.gz, .tgz or .zip archive, to dump the resources to with one file per resource, instead of stdout")
+		binary := cmd.BoolOpt("binary", false, "dump the resources to --archive as they are, along with their Content-Type, instead of as JSON")
 		baseURL := cmd.StringArg("BASEURL", "", "base URL to GET resources from. Must contain a __ids resource")
-		throttle := cmd.IntOpt("throttle", 10, "Limit request rate for resource GET requests (requests per second)")
+		throttle := throttleOpts(cmd, "", 10, "Limit request rate for resource GET requests (requests per second)")
+		auth := authOpts(cmd, "", "")
+		ids := idListOpts(cmd, "", "")
+		retry := retryOpts(cmd)
+		failures := failureOpts(cmd)
 		cmd.Action = func() {
-			if err := restutil.GetAllRest(*baseURL, *throttle); err != nil {
+			client := newClient(retry)
+			auth.register(client, *baseURL)
+			ids.register(client, *baseURL)
+			if *throttle.rps < 1 {
+				log.Fatalf("Invalid throttle %d", *throttle.rps)
+			}
+			if *archive != "" {
+				if *output != "" {
+					log.Fatal("Only one of --output and --archive can be given")
+				}
+				a, err := restutil.CreateArchive(*archive)
+				if err != nil {
+					log.Fatalf("Failed creating archive=%s: %s", *archive, err)
+				}
+				summary, err := client.DumpToArchive(ctx, *baseURL, throttle.limiter(), failures.open(), *binary, a)
+				if cerr := a.Close(); err == nil {
+					err = cerr
+				}
+				failures.close()
+				finish(summary, err)
+				return
+			}
+			var out io.Writer = os.Stdout
+			var f *os.File
+			if *output != "" {
+				var err error
+				if f, err = os.Create(*output); err != nil {
+					log.Fatalf("Failed creating output file=%s: %s", *output, err)
+				}
+				out = f
+				if *compress == "" {
+					*compress = restutil.CompressionFor(*output)
+				}
+			}
+			w, err := restutil.Compress(out, *compress)
+			if err != nil {
 				log.Fatal(err)
 			}
+			summary, err := client.GetAllRest(ctx, *baseURL, throttle.limiter(), failures.open(), w)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+			if f != nil {
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+			failures.close()
+			finish(summary, err)
 		}
 	})
 
 	app.Command("diff-ids", "Show differences between the ids available in two RESTful collections", func(cmd *cli.Cmd) {
 		sourceURL := cmd.StringArg("SOURCEURL", "", "base URL to GET resources from. Must contain a __ids resource")
 		destURL := cmd.StringArg("DESTURL", "", "base URL to GET resources from. Must contain a __ids resource")
+		sourceAuth := authOpts(cmd, "source-", " for the source")
+		destAuth := authOpts(cmd, "dest-", " for the destination")
+		sourceIDs := idListOpts(cmd, "source-", " of the source")
+		destIDs := idListOpts(cmd, "dest-", " of the destination")
+		retry := retryOpts(cmd)
 		cmd.Action = func() {
-			if err := restutil.DiffIDs(*sourceURL, *destURL); err != nil {
-				log.Fatal(err)
-			}
+			client := newClient(retry)
+			sourceAuth.register(client, *sourceURL)
+			destAuth.register(client, *destURL)
+			sourceIDs.register(client, *sourceURL)
+			destIDs.register(client, *destURL)
+			finish(client.DiffIDs(ctx, *sourceURL, *destURL, os.Stdout))
+		}
+	})
+
+	app.Command("diff-resources", "Show differences between the content of resources present in two RESTful collections", func(cmd *cli.Cmd) {
+		ignore := cmd.StringsOpt("ignore", []string{}, "field to ignore when comparing, either a field name or a JSON path such as $.meta.lastModified")
+		concurrency := cmd.IntOpt("concurrency", 16, "number of concurrent requests to use")
+		sourceURL := cmd.StringArg("SOURCEURL", "", "base URL to GET resources from. Must contain a __ids resource")
+		destURL := cmd.StringArg("DESTURL", "", "base URL to GET resources from. Must contain a __ids resource")
+		sourceAuth := authOpts(cmd, "source-", " for the source")
+		destAuth := authOpts(cmd, "dest-", " for the destination")
+		sourceIDs := idListOpts(cmd, "source-", " of the source")
+		destIDs := idListOpts(cmd, "dest-", " of the destination")
+		retry := retryOpts(cmd)
+		failures := failureOpts(cmd)
+		cmd.Action = func() {
+			client := newClient(retry)
+			sourceAuth.register(client, *sourceURL)
+			destAuth.register(client, *destURL)
+			sourceIDs.register(client, *sourceURL)
+			destIDs.register(client, *destURL)
+			summary, err := client.DiffResources(ctx, *sourceURL, *destURL, *ignore, *concurrency, failures.open(), os.Stdout)
+			failures.close()
+			finish(summary, err)
 		}
 	})
 
 	app.Command("sync-ids", "Sync resources between two RESTful JSON collections, using PUT and DELETE on the destination as needed", func(cmd *cli.Cmd) {
 		deletes := cmd.BoolOpt("deletes", false, "delete from destination those resources not present in source")
+		compare := cmd.BoolOpt("compare", false, "compare the content of resources present in both collections and re-PUT those that differ")
 		concurrency := cmd.IntOpt("concurrency", 32, "number of concurrent requests to use")
 		minExecTime := cmd.IntOpt("minExecTime", 0, "minimum amount of seconds it will take to execute one sync operation")
-		retries := cmd.IntOpt("retries", 2, "number of times a sync should be retried if it fails")
-		sourceFile := cmd.StringOpt("sourceFile", "", "path to the file the contains the source ids")
-		destFile := cmd.StringOpt("destFile", "", "path to the file that contains the destination ids")
+		sourceFile := cmd.StringOpt("sourceFile", "", "path to the file the contains the source ids, possibly compressed with gzip or zstd")
+		destFile := cmd.StringOpt("destFile", "", "path to the file that contains the destination ids, possibly compressed with gzip or zstd")
+		checkpoint := cmd.StringOpt("checkpoint", "", "path to a file recording each completed copy and delete, so the sync can be resumed")
+		resume := cmd.BoolOpt("resume", false, "skip the operations already recorded in the checkpoint file")
+		dryRun := cmd.BoolOpt("dry-run", false, "write the plan of creates, updates and deletes to stdout as JSON instead of applying it")
+		maxDeletes := cmd.IntOpt("max-deletes", 0, "abort before deleting anything if more than this many resources would be deleted (0 for no limit)")
+		maxDeleteRatio := cmd.Float64Opt("max-delete-ratio", 0, "abort before deleting anything if more than this fraction of the destination would be deleted, e.g. 0.1 (0 for no limit)")
+		sourceThrottle := throttleOpts(cmd, "source-", 0, "number of requests to make to the source a second (0 for no limit)")
+		destThrottle := throttleOpts(cmd, "dest-", 0, "number of requests to make to the destination a second (0 for no limit)")
 		sourceURL := cmd.StringArg("SOURCEURL", "", "base URL to GET resources from. Must contain a __ids resource")
 		destURL := cmd.StringArg("DESTURL", "", "base URL to GET resources from. Must contain a __ids resource")
+		sourceAuth := authOpts(cmd, "source-", " for the source")
+		destAuth := authOpts(cmd, "dest-", " for the destination")
+		sourceIDs := idListOpts(cmd, "source-", " of the source")
+		destIDs := idListOpts(cmd, "dest-", " of the destination")
+		retry := retryOpts(cmd)
+		failures := failureOpts(cmd)
 		cmd.Action = func() {
+			client := newClient(retry)
+			sourceAuth.register(client, *sourceURL)
+			destAuth.register(client, *destURL)
+			sourceIDs.register(client, *sourceURL)
+			destIDs.register(client, *destURL)
 			service := &restutil.SyncService{
-				DestIDsRetriever:   restutil.GetIDListRetriever(*destFile, *destURL),
-				SourceIDsRetriever: restutil.GetIDListRetriever(*sourceFile, *sourceURL),
+				Failures:           failures.open(),
+				DestIDsRetriever:   client.IDListRetriever(*destFile, *destURL),
+				SourceIDsRetriever: client.IDListRetriever(*sourceFile, *sourceURL),
 				Deletes:            *deletes,
+				CompareContent:     *compare,
+				Checkpoint:         *checkpoint,
+				Resume:             *resume,
+				DryRun:             *dryRun,
+				PlanOutput:         os.Stdout,
+				MaxDeletes:         *maxDeletes,
+				MaxDeleteRatio:     *maxDeleteRatio,
 				MaxConcurrentReqs:  *concurrency,
 				MinExecTime:        *minExecTime,
+				SourceLimiter:      sourceThrottle.limiter(),
+				DestLimiter:        destThrottle.limiter(),
 				DestURL:            *destURL,
 				SourceURL:          *sourceURL,
-				Retries:            *retries,
 			}
-			if err := restutil.SyncIDs(service); err != nil {
+			summary, err := client.SyncIDs(ctx, service)
+			failures.close()
+			finish(summary, err)
+		}
+	})
+
+	app.Command("apply-plan", "Apply a plan written by sync-ids --dry-run, using PUT and DELETE on the destination", func(cmd *cli.Cmd) {
+		concurrency := cmd.IntOpt("concurrency", 32, "number of concurrent requests to use")
+		minExecTime := cmd.IntOpt("minExecTime", 0, "minimum amount of seconds it will take to execute one sync operation")
+		checkpoint := cmd.StringOpt("checkpoint", "", "path to a file recording each completed copy and delete, so the plan can be resumed")
+		resume := cmd.BoolOpt("resume", false, "skip the operations already recorded in the checkpoint file")
+		maxDeletes := cmd.IntOpt("max-deletes", 0, "abort before deleting anything if more than this many resources would be deleted (0 for no limit)")
+		maxDeleteRatio := cmd.Float64Opt("max-delete-ratio", 0, "abort before deleting anything if more than this fraction of the destination would be deleted, e.g. 0.1 (0 for no limit)")
+		sourceThrottle := throttleOpts(cmd, "source-", 0, "number of requests to make to the source a second (0 for no limit)")
+		destThrottle := throttleOpts(cmd, "dest-", 0, "number of requests to make to the destination a second (0 for no limit)")
+		sourceURL := cmd.StringOpt("source", "", "base URL to GET resources from, instead of the one recorded in the plan")
+		destURL := cmd.StringOpt("dest", "", "base URL to PUT and DELETE resources on, instead of the one recorded in the plan")
+		planFile := cmd.StringArg("PLANFILE", "", "path to the plan file")
+		sourceAuth := authOpts(cmd, "source-", " for the source")
+		destAuth := authOpts(cmd, "dest-", " for the destination")
+		retry := retryOpts(cmd)
+		failures := failureOpts(cmd)
+		cmd.Action = func() {
+			client := newClient(retry)
+			plan, err := restutil.ReadSyncPlan(*planFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			service := &restutil.SyncService{
+				MaxConcurrentReqs: *concurrency,
+				MinExecTime:       *minExecTime,
+				SourceURL:         plan.SourceURL,
+				DestURL:           plan.DestURL,
+				Checkpoint:        *checkpoint,
+				Resume:            *resume,
+				MaxDeletes:        *maxDeletes,
+				MaxDeleteRatio:    *maxDeleteRatio,
+				SourceLimiter:     sourceThrottle.limiter(),
+				DestLimiter:       destThrottle.limiter(),
+				Failures:          failures.open(),
+			}
+			if *sourceURL != "" {
+				service.SourceURL = *sourceURL
+			}
+			if *destURL != "" {
+				service.DestURL = *destURL
+			}
+			sourceAuth.register(client, service.SourceURL)
+			destAuth.register(client, service.DestURL)
+			summary, err := client.ApplyPlan(ctx, service, plan)
+			failures.close()
+			finish(summary, err)
+		}
+	})
+
+	app.Command("replay-failures", "Run the operations recorded in a failure report, or in --dump-failed output, again", func(cmd *cli.Cmd) {
+		format := cmd.StringOpt("format", "report", "format of the failures file: report (written by --failure-report), resources (written by put-resources --dump-failed) or ids (written by put-binary-resources --dump-failed)")
+		idProp := cmd.StringOpt("id-property", "", "property name of identity property, for the resources format")
+		concurrency := cmd.IntOpt("concurrency", 16, "number of concurrent requests to use")
+		checkpoint := cmd.StringOpt("checkpoint", "", "path to a file recording each replayed operation, so the replay can be resumed")
+		resume := cmd.BoolOpt("resume", false, "skip the operations already recorded in the checkpoint file")
+		sourceURL := cmd.StringOpt("source", "", "base URL to GET resources from, when copies are to be replayed")
+		sourceThrottle := throttleOpts(cmd, "source-", 0, "number of requests to make to the source a second (0 for no limit)")
+		destThrottle := throttleOpts(cmd, "dest-", 0, "number of requests to make to the destination a second (0 for no limit)")
+		failuresFile := cmd.StringArg("FAILURES", "", "path to the failures file")
+		destURL := cmd.StringArg("DESTURL", "", "base URL to PUT and DELETE resources on")
+		sourceAuth := authOpts(cmd, "source-", " for the source")
+		destAuth := authOpts(cmd, "dest-", " for the destination")
+		retry := retryOpts(cmd)
+		failures := failureOpts(cmd)
+		cmd.Action = func() {
+			client := newClient(retry)
+			f, err := os.Open(*failuresFile)
+			if err != nil {
+				log.Fatalf("Failed opening failures file=%s: %s", *failuresFile, err)
+			}
+			var replay []restutil.Failure
+			switch *format {
+			case "report":
+				replay, err = restutil.ReadFailures(f)
+			case "resources":
+				if *idProp == "" {
+					log.Fatal("--id-property must be provided for the resources format")
+				}
+				replay, err = restutil.ReadDumpedResources(f, *idProp)
+			case "ids":
+				replay, err = restutil.ReadDumpedIDs(f)
+			default:
+				log.Fatalf("Invalid format %s", *format)
+			}
+			f.Close()
+			if err != nil {
 				log.Fatal(err)
 			}
+			if *sourceURL != "" {
+				sourceAuth.register(client, *sourceURL)
+			}
+			destAuth.register(client, *destURL)
+			service := &restutil.SyncService{
+				SourceURL:         *sourceURL,
+				DestURL:           *destURL,
+				MaxConcurrentReqs: *concurrency,
+				Checkpoint:        *checkpoint,
+				Resume:            *resume,
+				SourceLimiter:     sourceThrottle.limiter(),
+				DestLimiter:       destThrottle.limiter(),
+				Failures:          failures.open(),
+			}
+			summary, err := client.ReplayFailures(ctx, service, replay)
+			failures.close()
+			finish(summary, err)
 		}
 	})
 
 	app.Run(os.Args)
 }
+
+var (
+	netrcFile    *string
+	summaryFile  *string
+	socksProxy   *string
+	drainTimeout *int
+)
+
+// newClient returns the client a command makes its requests with, retrying them as configured by retry, dialing
+// through the SOCKS proxy when one is given and giving the requests in flight the drain timeout to complete.
+func newClient(retry *retryOptions) *restutil.Client {
+	transport := restutil.NewTransport()
+	if *socksProxy != "" {
+		dialer, err := proxy.SOCKS5("tcp", *socksProxy, nil, proxy.Direct)
+		if err != nil {
+			log.Fatalf("Invalid SOCKS proxy %s: %s", *socksProxy, err)
+		}
+		transport.Dial = dialer.Dial
+	}
+	return restutil.NewClient(
+		restutil.WithRetryPolicy(retry.policy()),
+		restutil.WithHTTPClient(&http.Client{Transport: transport}),
+		restutil.WithDrainTimeout(time.Duration(*drainTimeout)*time.Second),
+	)
+}
+
+type authOptions struct {
+	user       *string
+	pass       *string
+	passFile   *string
+	token      *string
+	tokenFile  *string
+	header     *string
+	headerFile *string
+
+	oauth2TokenURL         *string
+	oauth2ClientID         *string
+	oauth2ClientSecret     *string
+	oauth2ClientSecretFile *string
+	oauth2Scope            *string
+}
+
+// authOpts declares the authentication options of a command, with names starting with prefix. Each can also be set
+// from an environment variable, and secrets can be read from a file instead.
+func authOpts(cmd *cli.Cmd, prefix string, desc string) *authOptions {
+	opt := func(name string, d string, secret bool) *string {
+		return cmd.String(cli.StringOpt{
+			Name:      prefix + name,
+			Desc:      d + desc,
+			EnvVar:    "UP_RESTUTIL_" + strings.ToUpper(strings.Replace(prefix+name, "-", "_", -1)),
+			HideValue: secret,
+		})
+	}
+	return &authOptions{
+		user:       opt("user", "user for basic auth", false),
+		pass:       opt("pass", "password for basic auth", true),
+		passFile:   opt("pass-file", "file containing the password for basic auth", false),
+		token:      opt("token", "bearer token", true),
+		tokenFile:  opt("token-file", "file containing the bearer token", false),
+		header:     opt("auth-header", "header to send as \"Name: value\"", true),
+		headerFile: opt("auth-header-file", "file containing the header to send", false),
+
+		oauth2TokenURL:         opt("oauth2-token-url", "OAuth2 token URL to get client credentials tokens from", false),
+		oauth2ClientID:         opt("oauth2-client-id", "OAuth2 client id", false),
+		oauth2ClientSecret:     opt("oauth2-client-secret", "OAuth2 client secret", true),
+		oauth2ClientSecretFile: opt("oauth2-client-secret-file", "file containing the OAuth2 client secret", false),
+		oauth2Scope:            opt("oauth2-scope", "space separated OAuth2 scopes to request", false),
+	}
+}
+
+// register applies the configured authentication to every request made by client to baseURL. Secrets given as files are read
+// into the corresponding options. When no credentials are configured, those for the host in the netrc file are used.
+func (o *authOptions) register(client *restutil.Client, baseURL string) {
+	readSecret(o.pass, *o.passFile)
+	readSecret(o.token, *o.tokenFile)
+	readSecret(o.header, *o.headerFile)
+	readSecret(o.oauth2ClientSecret, *o.oauth2ClientSecretFile)
+
+	var auths []restutil.Authenticator
+	if *o.user != "" && *o.pass != "" {
+		auths = append(auths, restutil.BasicAuth(*o.user, *o.pass))
+	}
+	if *o.token != "" {
+		auths = append(auths, restutil.BearerToken(*o.token))
+	}
+	if *o.header != "" {
+		auth, err := restutil.ParseHeaderAuth(*o.header)
+		if err != nil {
+			log.Fatal(err)
+		}
+		auths = append(auths, auth)
+	}
+	if *o.oauth2TokenURL != "" {
+		auths = append(auths, restutil.OAuth2ClientCredentials(*o.oauth2TokenURL, *o.oauth2ClientID, *o.oauth2ClientSecret, strings.Fields(*o.oauth2Scope)))
+	}
+	if len(auths) == 0 && *netrcFile != "" {
+		netrc, err := restutil.ReadNetrc(*netrcFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if auth := netrc.Authenticator(baseURL); auth != nil {
+			auths = append(auths, auth)
+		}
+	}
+	if len(auths) > 0 {
+		client.SetAuthenticator(baseURL, restutil.ChainAuth(auths...))
+	}
+}
+
+func readSecret(into *string, path string) {
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed reading secret file=%s: %s", path, err)
+	}
+	*into = strings.TrimSpace(string(data))
+}
+
+type idListOptions struct {
+	format     *string
+	idField    *string
+	itemsField *string
+	paging     *string
+	nextField  *string
+	param      *string
+	pageSize   *int
+	limitParam *string
+}
+
+// idListOpts declares the options of a command describing the format and paging of the __ids of a collection, with
+// names starting with prefix.
+func idListOpts(cmd *cli.Cmd, prefix string, desc string) *idListOptions {
+	return &idListOptions{
+		format:     cmd.StringOpt(prefix+"ids-format", "", "format of the __ids"+desc+": stream of JSON entries (default), array, or text with one id per line"),
+		idField:    cmd.StringOpt(prefix+"ids-field", "", "field holding the id of each JSON entry of the __ids"+desc+" (default id)"),
+		itemsField: cmd.StringOpt(prefix+"ids-items", "", "field holding the array of entries when each page of the __ids"+desc+" is a JSON object"),
+		paging:     cmd.StringOpt(prefix+"ids-paging", "", "how the __ids"+desc+" are paged: link, cursor, page or offset (default not paged)"),
+		nextField:  cmd.StringOpt(prefix+"ids-next", "", "field of a page object holding the next link or cursor of the __ids"+desc),
+		param:      cmd.StringOpt(prefix+"ids-param", "", "query parameter carrying the cursor, page or offset of the __ids"+desc+" (default the name of the paging)"),
+		pageSize:   cmd.IntOpt(prefix+"ids-page-size", 0, "number of ids to ask for in each page of the __ids"+desc),
+		limitParam: cmd.StringOpt(prefix+"ids-limit-param", "", "query parameter carrying the page size of the __ids"+desc+" (default limit)"),
+	}
+}
+
+// register describes to client the __ids of the collections under baseURL, unless they are the default stream of JSON entries.
+func (o *idListOptions) register(client *restutil.Client, baseURL string) {
+	l := &restutil.IDList{
+		Format:     *o.format,
+		IDField:    *o.idField,
+		ItemsField: *o.itemsField,
+		Paging:     *o.paging,
+		NextField:  *o.nextField,
+		Param:      *o.param,
+		PageSize:   *o.pageSize,
+		LimitParam: *o.limitParam,
+	}
+	if *l == (restutil.IDList{}) {
+		return
+	}
+	if err := l.Validate(); err != nil {
+		log.Fatalf("Invalid __ids options: %s", err)
+	}
+	client.SetIDList(baseURL, l)
+}
+
+type retryOptions struct {
+	retries  *int
+	delay    *string
+	maxDelay *string
+	status   *[]int
+}
+
+// retryOpts declares the options of a command controlling how failed requests are retried.
+func retryOpts(cmd *cli.Cmd) *retryOptions {
+	return &retryOptions{
+		retries:  cmd.IntOpt("retries", restutil.DefaultRetryPolicy.MaxRetries, "number of times a failed request should be retried"),
+		delay:    cmd.StringOpt("retry-delay", restutil.DefaultRetryPolicy.BaseDelay.String(), "delay before the first retry, doubled for each further retry"),
+		maxDelay: cmd.StringOpt("retry-max-delay", restutil.DefaultRetryPolicy.MaxDelay.String(), "maximum delay between retries, unless the response asks for longer with Retry-After"),
+		status:   cmd.IntsOpt("retry-status", restutil.DefaultRetryPolicy.RetryableStatus, "response status codes to retry, network errors are always retried"),
+	}
+}
+
+// policy returns the configured retry policy.
+func (o *retryOptions) policy() *restutil.RetryPolicy {
+	delay, err := time.ParseDuration(*o.delay)
+	if err != nil {
+		log.Fatalf("Invalid retry delay %s: %s", *o.delay, err)
+	}
+	maxDelay, err := time.ParseDuration(*o.maxDelay)
+	if err != nil {
+		log.Fatalf("Invalid retry max delay %s: %s", *o.maxDelay, err)
+	}
+	return &restutil.RetryPolicy{
+		MaxRetries:      *o.retries,
+		BaseDelay:       delay,
+		MaxDelay:        maxDelay,
+		RetryableStatus: *o.status,
+	}
+}
+
+type throttleOptions struct {
+	rps *int
+	min *int
+	max *int
+}
+
+// throttleOpts declares the options of a command limiting the rate of its requests, with names starting with prefix.
+// A rate between the min and max options adapts to how the endpoint copes.
+func throttleOpts(cmd *cli.Cmd, prefix string, rps int, desc string) *throttleOptions {
+	return &throttleOptions{
+		rps: cmd.IntOpt(prefix+"throttle", rps, desc),
+		min: cmd.IntOpt(prefix+"throttle-min", 0, "lowest rate the throttle may adapt down to when the endpoint is overloaded (defaults to --"+prefix+"throttle, i.e. fixed)"),
+		max: cmd.IntOpt(prefix+"throttle-max", 0, "highest rate the throttle may adapt up to when the endpoint is healthy (defaults to --"+prefix+"throttle, i.e. fixed)"),
+	}
+}
+
+// limiter returns the configured rate limiter, or nil for no limit.
+func (o *throttleOptions) limiter() *restutil.RateLimiter {
+	return restutil.NewRateLimiter(float64(*o.rps), float64(*o.min), float64(*o.max))
+}
+
+type failureOptions struct {
+	path   *string
+	report *restutil.FailureReport
+}
+
+// stdoutIf returns stdout when dump is set, for the failed resources of --dump-failed, or nil otherwise.
+func stdoutIf(dump bool) io.Writer {
+	if dump {
+		return os.Stdout
+	}
+	return nil
+}
+
+// loadOpt declares the --load option, reading the resources to PUT from an archive written by dump-resources --archive.
+func loadOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("load", "", "directory, or .tar, .tar.gz, .tgz or .zip archive, written by dump-resources --archive to PUT the resources of, with their Content-Type")
+}
+
+// failureOpts declares the option of a command writing its failed operations to a report file.
+func failureOpts(cmd *cli.Cmd) *failureOptions {
+	return &failureOptions{
+		path: cmd.StringOpt("failure-report", "", "file to write a JSON line to for each failed operation, carrying on past failures instead of stopping"),
+	}
+}
+
+// open creates the report file, or returns nil if none was asked for.
+func (o *failureOptions) open() *restutil.FailureReport {
+	if *o.path == "" {
+		return nil
+	}
+	report, err := restutil.CreateFailureReport(*o.path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	o.report = report
+	return report
+}
+
+// close closes the report file, warning of any failure recorded in it.
+func (o *failureOptions) close() {
+	if n := o.report.Count(); n > 0 {
+		log.Warnf("%d operations failed, see %s", n, *o.path)
+	}
+	if err := o.report.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// interruptContext returns a context cancelled on the first SIGINT or SIGTERM, letting the command stop gracefully. A
+// second signal exits at once.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Warnf("Received %v, stopping once the requests in flight complete", sig)
+		cancel()
+		sig = <-sigs
+		log.Errorf("Received %v, exiting", sig)
+		os.Exit(exitAborted)
+	}()
+	return ctx
+}
+
+// serveMetrics serves the Prometheus metrics at /metrics on addr, in the background.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", restutil.MetricsHandler())
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed listening for metrics on %s: %s", addr, err)
+	}
+	log.Infof("Serving metrics on %s", l.Addr())
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Errorf("Failed serving metrics: %s", err)
+		}
+	}()
+}
+
+const (
+	// exitAborted is the status of a command that stopped before completing, as set by log.Fatal.
+	exitAborted = 1
+	// exitFailures is the status of a command that completed, but failed some of its operations.
+	exitFailures = 3
+)
+
+// finish writes the summary of a command, and exits with a status telling whether it completed, completed with
+// failures, or was aborted by err.
+func finish(summary *restutil.Summary, err error) {
+	if summary != nil {
+		writeSummary(summary)
+	}
+	if err != nil {
+		log.Error(err)
+		os.Exit(exitAborted)
+	}
+	if summary != nil && summary.Failed > 0 {
+		os.Exit(exitFailures)
+	}
+}
+
+func writeSummary(summary *restutil.Summary) {
+	if *summaryFile == "" {
+		json.NewEncoder(os.Stderr).Encode(summary)
+		return
+	}
+	f, err := os.Create(*summaryFile)
+	if err != nil {
+		log.Fatalf("Failed creating summary file=%s: %s", *summaryFile, err)
+	}
+	err = json.NewEncoder(f).Encode(summary)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatalf("Failed writing summary file=%s: %s", *summaryFile, err)
+	}
+}
@@ -0,0 +1,452 @@
+package restutil
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jsonSuffix        = ".json"
+	contentTypeSuffix = ".content-type"
+)
+
+// ArchiveWriter stores resources one file per resource. JSON resources are stored as <id>.json, others as <id> along
+// with their Content-Type in <id>.content-type.
+type ArchiveWriter interface {
+	Add(id string, contentType string, body []byte) error
+	Close() error
+}
+
+// CreateArchive creates an archive of the kind given by the extension of path: a tar archive for .tar, a gzipped tar
+// archive for .tar.gz or .tgz, a zip archive for .zip, and a directory otherwise.
+func CreateArchive(p string) (ArchiveWriter, error) {
+	switch archiveKind(p) {
+	case "tar", "tgz":
+		f, err := os.Create(p)
+		if err != nil {
+			return nil, err
+		}
+		a := &tarArchiveWriter{f: f}
+		if archiveKind(p) == "tgz" {
+			a.gz = gzip.NewWriter(f)
+			a.tw = tar.NewWriter(a.gz)
+		} else {
+			a.tw = tar.NewWriter(f)
+		}
+		return a, nil
+	case "zip":
+		f, err := os.Create(p)
+		if err != nil {
+			return nil, err
+		}
+		return &zipArchiveWriter{f: f, zw: zip.NewWriter(f)}, nil
+	default:
+		if err := os.MkdirAll(p, 0755); err != nil {
+			return nil, err
+		}
+		return dirArchiveWriter(p), nil
+	}
+}
+
+func archiveKind(p string) string {
+	switch {
+	case strings.HasSuffix(p, ".tar"):
+		return "tar"
+	case strings.HasSuffix(p, ".tar.gz"), strings.HasSuffix(p, ".tgz"):
+		return "tgz"
+	case strings.HasSuffix(p, ".zip"):
+		return "zip"
+	default:
+		return "dir"
+	}
+}
+
+// archiveFiles returns the files storing a resource, as names and contents.
+func archiveFiles(id string, contentType string, body []byte) ([]string, [][]byte, error) {
+	if err := checkArchivable(id); err != nil {
+		return nil, nil, err
+	}
+	if isJSON(contentType) {
+		return []string{id + jsonSuffix}, [][]byte{body}, nil
+	}
+	if contentType == "" {
+		return []string{id}, [][]byte{body}, nil
+	}
+	// the content type goes first, so that it is known when reading the resource from a tar stream
+	return []string{id + contentTypeSuffix, id}, [][]byte{[]byte(contentType), body}, nil
+}
+
+// checkArchivable fails for the ids that cannot be the name of a file in an archive.
+func checkArchivable(id string) error {
+	if id == "" || id == "." || id == ".." || path.Base(id) != id || strings.ContainsRune(id, '\\') {
+		return fmt.Errorf("cannot archive resource with ID=%q", id)
+	}
+	return nil
+}
+
+func isJSON(contentType string) bool {
+	return contentType == "application/json" || strings.HasPrefix(contentType, "application/json;")
+}
+
+type dirArchiveWriter string
+
+func (d dirArchiveWriter) Add(id string, contentType string, body []byte) error {
+	names, contents, err := archiveFiles(id, contentType, body)
+	if err != nil {
+		return err
+	}
+	for i, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(string(d), name), contents[i], 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d dirArchiveWriter) Close() error {
+	return nil
+}
+
+type tarArchiveWriter struct {
+	f  *os.File
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarArchiveWriter) Add(id string, contentType string, body []byte) error {
+	names, contents, err := archiveFiles(id, contentType, body)
+	if err != nil {
+		return err
+	}
+	for i, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(contents[i])), ModTime: time.Now()}
+		if err := a.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := a.tw.Write(contents[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *tarArchiveWriter) Close() error {
+	err := a.tw.Close()
+	if a.gz != nil {
+		if gerr := a.gz.Close(); err == nil {
+			err = gerr
+		}
+	}
+	if ferr := a.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+type zipArchiveWriter struct {
+	f  *os.File
+	zw *zip.Writer
+}
+
+func (a *zipArchiveWriter) Add(id string, contentType string, body []byte) error {
+	names, contents, err := archiveFiles(id, contentType, body)
+	if err != nil {
+		return err
+	}
+	for i, name := range names {
+		w, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(contents[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *zipArchiveWriter) Close() error {
+	err := a.zw.Close()
+	if ferr := a.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// DumpToArchive writes every resource of the collection at baseURL to archive with DefaultClient.
+func DumpToArchive(ctx context.Context, baseURL string, limiter *RateLimiter, failures *FailureReport, binary bool, archive ArchiveWriter) (*Summary, error) {
+	return DefaultClient.DumpToArchive(ctx, baseURL, limiter, failures, binary, archive)
+}
+
+// DumpToArchive writes every resource of the collection at baseURL to archive. Unless binary is set, the resources must
+// be JSON and are stored as <id>.json; otherwise they are stored as they are, along with their Content-Type.
+//
+// Resources that cannot be read, or whose id cannot name a file, are recorded in failures when given, rather than
+// failing the whole dump. The dump stops once the resources in flight are written when ctx is done.
+func (c *Client) DumpToArchive(ctx context.Context, baseURL string, limiter *RateLimiter, failures *FailureReport, binary bool, archive ArchiveWriter) (*Summary, error) {
+	return c.dumpAll(ctx, baseURL, limiter, failures, binary, func(r *rawResource) error {
+		if err := checkArchivable(r.id); err != nil {
+			return &ResourceError{Op: opGet, ID: r.id, Err: err}
+		}
+		contentType := r.contentType
+		if !binary {
+			contentType = "application/json"
+		}
+		return archive.Add(r.id, contentType, r.body)
+	})
+}
+
+// ReadArchive calls fn for every resource stored in the archive at path, as written by CreateArchive, stopping at the
+// first error it returns.
+func ReadArchive(p string, fn func(id string, contentType string, body []byte) error) error {
+	resources := make(chan *rawResource)
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- readArchive(p, resources, done)
+		close(resources)
+	}()
+	for r := range resources {
+		if err := fn(r.id, r.contentType, r.body); err != nil {
+			// stop reading the rest of the archive, and wait for it to be closed
+			close(done)
+			<-errs
+			return err
+		}
+	}
+	return <-errs
+}
+
+// readArchive sends the resources stored in the archive at path to resources, until done is closed.
+func readArchive(p string, resources chan<- *rawResource, done <-chan struct{}) error {
+	switch archiveKind(p) {
+	case "tar", "tgz":
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if archiveKind(p) == "tgz" {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				return err
+			}
+			defer gz.Close()
+			r = gz
+		}
+		return readTar(tar.NewReader(r), resources, done)
+	case "zip":
+		zr, err := zip.OpenReader(p)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		files := make(map[string]*zip.File)
+		for _, f := range zr.File {
+			files[f.Name] = f
+		}
+		return readFiles(zipNames(files), func(name string) ([]byte, error) {
+			f, found := files[name]
+			if !found {
+				return nil, os.ErrNotExist
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return ioutil.ReadAll(rc)
+		}, resources, done)
+	default:
+		infos, err := ioutil.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		var files []string
+		for _, info := range infos {
+			if !info.IsDir() {
+				files = append(files, info.Name())
+			}
+		}
+		return readFiles(files, func(name string) ([]byte, error) {
+			return ioutil.ReadFile(filepath.Join(p, name))
+		}, resources, done)
+	}
+}
+
+func zipNames(files map[string]*zip.File) []string {
+	n := make([]string, 0, len(files))
+	for name := range files {
+		n = append(n, name)
+	}
+	sort.Strings(n)
+	return n
+}
+
+// readFiles reads the resources stored in files, looking up the Content-Type of each, until done is closed.
+func readFiles(files []string, read func(name string) ([]byte, error), resources chan<- *rawResource, done <-chan struct{}) error {
+	for _, name := range files {
+		if strings.HasSuffix(name, contentTypeSuffix) {
+			continue
+		}
+		body, err := read(name)
+		if err != nil {
+			return err
+		}
+		r := &rawResource{id: name, contentType: "application/octet-stream", body: body}
+		if strings.HasSuffix(name, jsonSuffix) {
+			r.id = strings.TrimSuffix(name, jsonSuffix)
+			r.contentType = "application/json"
+		} else if ct, err := read(name + contentTypeSuffix); err == nil {
+			r.contentType = string(bytes.TrimSpace(ct))
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if !sendResource(resources, done, r) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// readTar reads the resources stored in a tar stream, where the Content-Type of a resource precedes it, until done is
+// closed.
+func readTar(tr *tar.Reader, resources chan<- *rawResource, done <-chan struct{}) error {
+	contentTypes := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		name := path.Base(hdr.Name)
+		body, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasSuffix(name, contentTypeSuffix):
+			contentTypes[strings.TrimSuffix(name, contentTypeSuffix)] = string(bytes.TrimSpace(body))
+		case strings.HasSuffix(name, jsonSuffix):
+			if !sendResource(resources, done, &rawResource{id: strings.TrimSuffix(name, jsonSuffix), contentType: "application/json", body: body}) {
+				return nil
+			}
+		default:
+			ct, found := contentTypes[name]
+			if !found {
+				ct = "application/octet-stream"
+			}
+			delete(contentTypes, name)
+			if !sendResource(resources, done, &rawResource{id: name, contentType: ct, body: body}) {
+				return nil
+			}
+		}
+	}
+}
+
+// sendResource sends r to resources, unless done is closed first, and tells whether it did.
+func sendResource(resources chan<- *rawResource, done <-chan struct{}, r *rawResource) bool {
+	select {
+	case resources <- r:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// LoadArchive PUTs every resource stored in the archive at path to the collection at baseURL with DefaultClient.
+func LoadArchive(ctx context.Context, p string, baseURL string, user string, pass string, conns int, limiter *RateLimiter, failures *FailureReport) (*Summary, error) {
+	return DefaultClient.LoadArchive(ctx, p, baseURL, user, pass, conns, limiter, failures)
+}
+
+// LoadArchive PUTs every resource stored in the archive at path to the collection at baseURL, with its Content-Type.
+//
+// The first failure stops the load, unless failures is given to record it in. The load stops once the PUTs in flight
+// complete when ctx is done.
+func (c *Client) LoadArchive(ctx context.Context, p string, baseURL string, user string, pass string, conns int, limiter *RateLimiter, failures *FailureReport) (*Summary, error) {
+	summary := c.newSummary()
+	reqCtx, cancel := c.drainContext(ctx)
+	defer cancel()
+	rp := &resourcePutter{client: c, ctx: reqCtx, baseURL: baseURL, user: user, pass: pass, limiter: limiter, failures: failures, summary: summary, progress: c.newProgress(opPut, 0)}
+	defer rp.progress.finish("Done puts")
+
+	resources := make(chan *rawResource)
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range resources {
+				if err := rp.putArchived(r); err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	err := ReadArchive(p, func(id string, contentType string, body []byte) error {
+		summary.addRead(1)
+		select {
+		case resources <- &rawResource{id: id, contentType: contentType, body: body}:
+			return nil
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(resources)
+	wg.Wait()
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	return summary.finish(), err
+}
+
+func (rp *resourcePutter) putArchived(r *rawResource) error {
+	rp.progress.increment()
+	u, err := generatePutURL(r.id, rp.baseURL)
+	if err == nil {
+		err = rp.put(u.String(), bytes.NewReader(r.body), r.contentType)
+	}
+	if err == nil {
+		rp.summary.addWritten(1)
+		rp.summary.addOp(opPut)
+		return nil
+	}
+	rp.summary.addFailed(opPut)
+	if rp.failures == nil {
+		return &ResourceError{Op: opPut, ID: r.id, Err: err}
+	}
+	rp.client.log.Errorf("PUT ID=%v, Error=%v", r.id, err.Error())
+	if isJSON(r.contentType) {
+		return rp.failures.reportResource(opPut, r.id, r.body, err)
+	}
+	return rp.failures.Report(opPut, r.id, err)
+}
@@ -0,0 +1,139 @@
+package restutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestArchive_RoundTripsResources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"dump", "dump.tar", "dump.tar.gz", "dump.zip"} {
+		p := filepath.Join(dir, name)
+		a, err := CreateArchive(p)
+		assert.NoError(t, err)
+		assert.NoError(t, a.Add("UUID-1", "application/json; charset=utf-8", []byte(`{"id":"UUID-1"}`)))
+		assert.NoError(t, a.Add("UUID-2", "image/png", []byte("PNG")))
+		assert.NoError(t, a.Add("UUID-3", "", []byte("data")))
+		assert.Error(t, a.Add("../UUID-4", "", []byte("data")))
+		assert.NoError(t, a.Close())
+
+		read := map[string]string{}
+		err = ReadArchive(p, func(id string, contentType string, body []byte) error {
+			read[id] = contentType + " " + string(body)
+			return nil
+		})
+		assert.NoError(t, err, name)
+		assert.Equal(t, map[string]string{
+			"UUID-1": `application/json {"id":"UUID-1"}`,
+			"UUID-2": "image/png PNG",
+			"UUID-3": "application/octet-stream data",
+		}, read, name)
+	}
+}
+
+func TestDumpToArchive_LoadArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+
+	p := filepath.Join(dir, "dump.tgz")
+	a, err := CreateArchive(p)
+	assert.NoError(t, err)
+	summary, err := DumpToArchive(context.Background(), source.URL, nil, nil, false, a)
+	assert.NoError(t, err)
+	assert.NoError(t, a.Close())
+	assert.Equal(t, int64(2), summary.Written)
+
+	summary, err = LoadArchive(context.Background(), p, dest.URL, "", "", 2, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), summary.Written)
+	assert.Equal(t, []string{"UUID-1", "UUID-2"}, dest.puts())
+	assert.Equal(t, `{"id":"UUID-1"}`, dest.get("UUID-1"))
+	for _, req := range dest.requests("PUT") {
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	}
+}
+
+func TestReadFiles_StopsWhenDone(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	var reads []string
+	err := readFiles([]string{"UUID-1.json", "UUID-2.json", "UUID-3.json"}, func(name string) ([]byte, error) {
+		reads = append(reads, name)
+		return []byte(`{}`), nil
+	}, make(chan *rawResource), done)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-1.json"}, reads)
+}
+
+func TestReadArchive_StopsReadingOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "dump.tar")
+	a, err := CreateArchive(p)
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, a.Add(fmt.Sprintf("UUID-%03d", i), "application/json", []byte(`{}`)))
+	}
+	assert.NoError(t, a.Close())
+
+	calls := 0
+	err = ReadArchive(p, func(id string, contentType string, body []byte) error {
+		calls++
+		return errors.New("stop")
+	})
+	assert.EqualError(t, err, "stop")
+	assert.Equal(t, 1, calls)
+	buf := make([]byte, 1<<20)
+	assert.False(t, strings.Contains(string(buf[:runtime.Stack(buf, true)]), "readTar"), "archive still read after ReadArchive returned")
+}
+
+func TestDumpToArchive_ReportsUnarchivableIDs(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID/2": `{"id":"UUID/2"}`,
+		"UUID-3": `{"id":"UUID-3"}`,
+	})
+	defer source.Close()
+	dir, err := ioutil.TempDir("", "archive")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	a, err := CreateArchive(filepath.Join(dir, "dump"))
+	assert.NoError(t, err)
+
+	report := new(bytes.Buffer)
+	summary, err := DumpToArchive(context.Background(), source.URL, nil, &FailureReport{enc: json.NewEncoder(report)}, false, a)
+	assert.NoError(t, err)
+	assert.NoError(t, a.Close())
+	assert.Equal(t, int64(2), summary.Written)
+	assert.Equal(t, int64(1), summary.Failed)
+	failures, err := ReadFailures(report)
+	assert.NoError(t, err)
+	if assert.Len(t, failures, 1) {
+		assert.Equal(t, "get", failures[0].Op)
+		assert.Equal(t, "UUID/2", failures[0].ID)
+	}
+}
@@ -0,0 +1,217 @@
+package restutil
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Authenticator adds credentials to an outgoing request.
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+type basicAuth struct {
+	user string
+	pass string
+}
+
+// BasicAuth authenticates requests with HTTP basic auth.
+func BasicAuth(user, pass string) Authenticator {
+	return &basicAuth{user: user, pass: pass}
+}
+
+func (a *basicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.user, a.pass)
+	return nil
+}
+
+type headerAuth struct {
+	name  string
+	value string
+}
+
+// HeaderAuth authenticates requests by setting an arbitrary header, such as X-Api-Key.
+func HeaderAuth(name, value string) Authenticator {
+	return &headerAuth{name: name, value: value}
+}
+
+// ParseHeaderAuth builds a HeaderAuth from a "Name: value" string.
+func ParseHeaderAuth(header string) (Authenticator, error) {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return nil, fmt.Errorf("invalid auth header %q, expected \"Name: value\"", header)
+	}
+	return HeaderAuth(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])), nil
+}
+
+func (a *headerAuth) Authenticate(req *http.Request) error {
+	req.Header.Set(a.name, a.value)
+	return nil
+}
+
+// BearerToken authenticates requests with a static bearer token.
+func BearerToken(token string) Authenticator {
+	return HeaderAuth("Authorization", "Bearer "+token)
+}
+
+type chainAuth []Authenticator
+
+// ChainAuth applies each of the given authenticators in turn.
+func ChainAuth(auths ...Authenticator) Authenticator {
+	return chainAuth(auths)
+}
+
+// invalidatorOf returns auth as an invalidator, or nil if none of its credentials can be refreshed.
+func invalidatorOf(auth Authenticator) invalidator {
+	if c, ok := auth.(chainAuth); ok {
+		for _, a := range c {
+			if invalidatorOf(a) != nil {
+				return c
+			}
+		}
+		return nil
+	}
+	inv, _ := auth.(invalidator)
+	return inv
+}
+
+func (c chainAuth) Invalidate() {
+	for _, a := range c {
+		if inv, ok := a.(invalidator); ok {
+			inv.Invalidate()
+		}
+	}
+}
+
+func (c chainAuth) Authenticate(req *http.Request) error {
+	for _, a := range c {
+		if err := a.Authenticate(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authRegistry holds the authenticators of a client by base URL.
+type authRegistry struct {
+	sync.RWMutex
+	byBaseURL map[string]Authenticator
+}
+
+func newAuthRegistry() *authRegistry {
+	return &authRegistry{byBaseURL: make(map[string]Authenticator)}
+}
+
+// SetAuthenticator registers auth for every request made by DefaultClient to a URL under baseURL. When several base
+// URLs match a request, the longest one is used.
+func SetAuthenticator(baseURL string, auth Authenticator) {
+	DefaultClient.SetAuthenticator(baseURL, auth)
+}
+
+func (r *authRegistry) set(baseURL string, auth Authenticator) {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL = baseURL + "/"
+	}
+	r.Lock()
+	defer r.Unlock()
+	if auth == nil {
+		delete(r.byBaseURL, baseURL)
+		return
+	}
+	r.byBaseURL[baseURL] = auth
+}
+
+func (r *authRegistry) authenticatorFor(u string) Authenticator {
+	r.RLock()
+	defer r.RUnlock()
+	var match string
+	for baseURL := range r.byBaseURL {
+		if underBaseURL(u, baseURL) && len(baseURL) > len(match) {
+			match = baseURL
+		}
+	}
+	if match == "" {
+		return nil
+	}
+	return r.byBaseURL[match]
+}
+
+// underBaseURL tells whether u is baseURL, which ends with a slash, or a URL under it.
+func underBaseURL(u string, baseURL string) bool {
+	return strings.HasPrefix(u, baseURL) || u+"/" == baseURL
+}
+
+// authTransport applies the authenticators registered with its client to requests before handing them to the next
+// RoundTripper.
+type authTransport struct {
+	client *Client
+	next   http.RoundTripper
+}
+
+type clientKey struct{}
+
+type noAuthKey struct{}
+
+// clientOf returns the client making req, as seen by its Authenticator, or DefaultClient when the request is not made
+// by a client.
+func clientOf(req *http.Request) *Client {
+	if c, ok := req.Context().Value(clientKey{}).(*Client); ok {
+		return c
+	}
+	return DefaultClient
+}
+
+// withoutAuth returns a context making requests that are not authenticated, such as those fetching credentials.
+func withoutAuth(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noAuthKey{}, true)
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Context().Value(noAuthKey{}) != nil {
+		return t.next.RoundTrip(req)
+	}
+	auth := t.client.auth.authenticatorFor(req.URL.String())
+	if auth == nil {
+		return t.next.RoundTrip(req)
+	}
+	resp, err := t.authenticatedRoundTrip(auth, req, req.Body)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	inv := invalidatorOf(auth)
+	if inv == nil {
+		return resp, err
+	}
+	// the credentials were rejected, so get fresh ones and try once more if the body can be sent again
+	inv.Invalidate()
+	body := req.Body
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, err
+		}
+		if body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return t.authenticatedRoundTrip(auth, req, body)
+}
+
+func (t *authTransport) authenticatedRoundTrip(auth Authenticator, req *http.Request, body io.ReadCloser) (*http.Response, error) {
+	// a RoundTripper must not modify the caller's request
+	authReq := req.Clone(context.WithValue(req.Context(), clientKey{}, t.client))
+	authReq.Body = body
+	if err := auth.Authenticate(authReq); err != nil {
+		if body != nil {
+			body.Close()
+		}
+		return nil, err
+	}
+	return t.next.RoundTrip(authReq)
+}
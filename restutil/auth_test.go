@@ -0,0 +1,67 @@
+package restutil
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticators(t *testing.T) {
+	tests := []struct {
+		auth   Authenticator
+		header string
+		value  string
+	}{
+		{BasicAuth("user", "pass"), "Authorization", "Basic dXNlcjpwYXNz"},
+		{BearerToken("token"), "Authorization", "Bearer token"},
+		{HeaderAuth("X-Api-Key", "key"), "X-Api-Key", "key"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		assert.NoError(t, test.auth.Authenticate(req))
+		assert.Equal(t, test.value, req.Header.Get(test.header))
+	}
+}
+
+func TestParseHeaderAuth(t *testing.T) {
+	auth, err := ParseHeaderAuth("X-Api-Key: some:key")
+	assert.NoError(t, err)
+	assert.Equal(t, HeaderAuth("X-Api-Key", "some:key"), auth)
+
+	_, err = ParseHeaderAuth("X-Api-Key")
+	assert.Error(t, err)
+}
+
+func TestSetAuthenticator_AppliesToRequestsUnderBaseURL(t *testing.T) {
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	SetAuthenticator(server.URL+"/source", ChainAuth(BasicAuth("user", "pass"), HeaderAuth("X-Api-Key", "source")))
+	defer SetAuthenticator(server.URL+"/source", nil)
+	SetAuthenticator(server.URL+"/source/nested/", HeaderAuth("X-Api-Key", "nested"))
+	defer SetAuthenticator(server.URL+"/source/nested/", nil)
+
+	_, err := DefaultClient.doGet(context.Background(), server.URL+"/source", "UUID-1")
+	assert.NoError(t, err)
+	_, err = DefaultClient.doGet(context.Background(), server.URL+"/source/nested", "UUID-1")
+	assert.NoError(t, err)
+	_, err = DefaultClient.doGet(context.Background(), server.URL+"/sourcery", "UUID-1")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3, len(headers))
+	user, pass, _ := (&http.Request{Header: headers[0]}).BasicAuth()
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass", pass)
+	assert.Equal(t, "source", headers[0].Get("X-Api-Key"))
+	assert.Equal(t, "", headers[1].Get("Authorization"))
+	assert.Equal(t, "nested", headers[1].Get("X-Api-Key"))
+	assert.Equal(t, "", headers[2].Get("Authorization"))
+	assert.Equal(t, "", headers[2].Get("X-Api-Key"))
+}
@@ -0,0 +1,187 @@
+package restutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	opCreate  = "create"
+	opCompare = "compare"
+	opUpdate  = "update"
+	opDelete  = "delete"
+	// operations of the other commands, only named in failure reports
+	opCopy = "copy"
+	opGet  = "get"
+	opPut  = "put"
+	opDiff = "diff"
+	// the plan of a sync, recorded in its checkpoint before any operation
+	opPlan = "plan"
+)
+
+type checkpointEntry struct {
+	Op string `json:"op"`
+	ID string `json:"id"`
+}
+
+// plannedSync is the plan of a sync, along with the options it was planned with.
+type plannedSync struct {
+	Plan           *SyncPlan `json:"plan,omitempty"`
+	Deletes        bool      `json:"deletes,omitempty"`
+	CompareContent bool      `json:"compare,omitempty"`
+}
+
+// checkpointLine is a line of the journal, holding either a completed operation or the plan of the sync.
+type checkpointLine struct {
+	checkpointEntry
+	plannedSync
+}
+
+// checkpoint is an append-only journal of completed sync operations, one JSON object per line. A sync records its
+// plan first, so that it can be resumed without retrieving the ids of the collections again.
+//
+// A nil *checkpoint is valid and records nothing, so callers do not need to check whether checkpointing is enabled.
+type checkpoint struct {
+	sync.Mutex
+	file *os.File
+	done map[checkpointEntry]struct{}
+	// plan is the last plan loaded from the journal, if any
+	plan *plannedSync
+}
+
+// openCheckpoint opens the journal at path. When resume is true the operations already recorded in an existing journal
+// are loaded and new entries are appended, otherwise the journal is truncated. Loading is logged to logger.
+func openCheckpoint(path string, resume bool, logger *log.Logger) (*checkpoint, error) {
+	c := &checkpoint{done: make(map[checkpointEntry]struct{})}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		if err := c.load(path, logger); err != nil {
+			return nil, err
+		}
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening checkpoint file=%s: %s", path, err)
+	}
+	c.file = f
+	if resume {
+		if err := c.terminateLastLine(path); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// terminateLastLine makes sure an incomplete entry left by a killed run does not run into the next one.
+func (c *checkpoint) terminateLastLine(path string) error {
+	info, err := c.file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading checkpoint file=%s: %s", path, err)
+	}
+	defer f.Close()
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return fmt.Errorf("error reading checkpoint file=%s: %s", path, err)
+	}
+	if last[0] != '\n' {
+		_, err = c.file.Write([]byte("\n"))
+	}
+	return err
+}
+
+func (c *checkpoint) load(path string, logger *log.Logger) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error opening checkpoint file=%s: %s", path, err)
+	}
+	defer f.Close()
+
+	// a plan may be too long a line for a bufio.Scanner
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var l checkpointLine
+			if jsonErr := json.Unmarshal(line, &l); jsonErr != nil {
+				// the last line may be incomplete if the previous run was killed while writing it
+				logger.Warnf("Ignoring invalid checkpoint entry=%q in file=%s", bytes.TrimSpace(line), path)
+			} else if l.Op == opPlan {
+				c.plan = &l.plannedSync
+			} else {
+				c.done[l.checkpointEntry] = struct{}{}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading checkpoint file=%s: %s", path, err)
+		}
+	}
+	logger.Infof("Loaded %d completed operations from checkpoint file=%s", len(c.done), path)
+	return nil
+}
+
+// loadedPlan returns the plan recorded by the run being resumed, or nil when there is none.
+func (c *checkpoint) loadedPlan() *plannedSync {
+	if c == nil {
+		return nil
+	}
+	return c.plan
+}
+
+// recordPlan records the plan the operations that follow carry out.
+func (c *checkpoint) recordPlan(plan plannedSync) error {
+	if c == nil {
+		return nil
+	}
+	return c.write(checkpointLine{checkpointEntry: checkpointEntry{Op: opPlan}, plannedSync: plan})
+}
+
+func (c *checkpoint) completed(op, id string) bool {
+	if c == nil {
+		return false
+	}
+	_, found := c.done[checkpointEntry{Op: op, ID: id}]
+	return found
+}
+
+func (c *checkpoint) record(op, id string) error {
+	if c == nil {
+		return nil
+	}
+	return c.write(checkpointEntry{Op: op, ID: id})
+}
+
+func (c *checkpoint) write(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	_, err = c.file.Write(append(line, '\n'))
+	return err
+}
+
+func (c *checkpoint) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.file.Close()
+}
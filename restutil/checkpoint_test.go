@@ -0,0 +1,238 @@
+package restutil
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestSyncIDs_ResumeSkipsCompletedOperations(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{
+		"UUID-3": `{"id":"UUID-3"}`,
+		"UUID-4": `{"id":"UUID-4"}`,
+	})
+	defer dest.Close()
+
+	f, err := ioutil.TempFile("", "checkpoint")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("{\"op\":\"create\",\"id\":\"UUID-1\"}\n{\"op\":\"delete\",\"id\":\"UUID-3\"}\n{\"op\":\"crea")
+	f.Close()
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1", "UUID-2"},
+		DestIDsRetriever:   staticIDListRetriever{"UUID-3", "UUID-4"},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+		Deletes:            true,
+		Checkpoint:         f.Name(),
+		Resume:             true,
+	}
+
+	_, err = SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-2"}, dest.puts())
+	assert.Equal(t, 1, len(dest.requests("DELETE")))
+	assert.Equal(t, "/UUID-4", dest.requests("DELETE")[0].URL.Path)
+
+	journal, err := openCheckpoint(f.Name(), true, log.StandardLogger())
+	assert.NoError(t, err)
+	defer journal.Close()
+	for _, e := range []checkpointEntry{{opCreate, "UUID-1"}, {opCreate, "UUID-2"}, {opDelete, "UUID-3"}, {opDelete, "UUID-4"}} {
+		assert.True(t, journal.completed(e.Op, e.ID), "expected %v to be recorded", e)
+	}
+}
+
+func TestSyncIDs_ResumeReusesRecordedPlan(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{
+		"UUID-3": `{"id":"UUID-3"}`,
+		"UUID-4": `{"id":"UUID-4"}`,
+	})
+	defer dest.Close()
+	dest.fail("PUT", "UUID-2", 1)
+
+	f, err := ioutil.TempFile("", "checkpoint")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.Close()
+
+	c := NewClient(WithRetryPolicy(&RetryPolicy{}))
+	service := &SyncService{
+		SourceIDsRetriever: c.IDListRetriever("", source.URL+"/"),
+		DestIDsRetriever:   c.IDListRetriever("", dest.URL+"/"),
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+		Deletes:            true,
+		Checkpoint:         f.Name(),
+	}
+	_, err = c.SyncIDs(context.Background(), service)
+	assert.Error(t, err)
+
+	service.Resume = true
+	_, err = c.SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, countPath(source.requests("GET"), "/__ids"))
+	assert.Equal(t, 1, countPath(dest.requests("GET"), "/__ids"))
+	assert.Equal(t, `{"id":"UUID-1"}`, dest.get("UUID-1"))
+	assert.Equal(t, `{"id":"UUID-2"}`, dest.get("UUID-2"))
+	assert.Equal(t, 2, len(dest.requests("DELETE")))
+}
+
+func TestSyncIDs_ResumeRefusesOtherSync(t *testing.T) {
+	source := newFakeCollection(map[string]string{"UUID-1": `{"id":"UUID-1"}`})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{"UUID-2": `{"id":"UUID-2"}`})
+	defer dest.Close()
+	other := newFakeCollection(map[string]string{"UUID-2": `{"id":"UUID-2"}`})
+	defer other.Close()
+
+	tests := []struct {
+		name    string
+		destURL string
+		deletes bool
+	}{
+		{"other destination", other.URL, true},
+		{"without deletes", dest.URL, false},
+	}
+	for _, test := range tests {
+		f, err := ioutil.TempFile("", "checkpoint")
+		assert.NoError(t, err)
+		defer os.Remove(f.Name())
+		f.Close()
+		journal, err := openCheckpoint(f.Name(), false, log.StandardLogger())
+		assert.NoError(t, err)
+		plan := &SyncPlan{SourceURL: source.URL, DestURL: dest.URL, Create: []string{"UUID-1"}, Update: []string{}, Delete: []string{"UUID-2"}, DestSize: 1}
+		assert.NoError(t, journal.recordPlan(plannedSync{Plan: plan, Deletes: true}))
+		journal.Close()
+
+		service := &SyncService{
+			SourceIDsRetriever: staticIDListRetriever{"UUID-1"},
+			DestIDsRetriever:   staticIDListRetriever{"UUID-2"},
+			SourceURL:          source.URL,
+			DestURL:            test.destURL,
+			MaxConcurrentReqs:  1,
+			Deletes:            test.deletes,
+			Checkpoint:         f.Name(),
+			Resume:             true,
+		}
+		_, err = SyncIDs(context.Background(), service)
+		assert.Error(t, err, test.name)
+		for _, c := range []*fakeCollection{dest, other} {
+			assert.Empty(t, c.requests("PUT"), test.name)
+			assert.Empty(t, c.requests("DELETE"), test.name)
+		}
+	}
+}
+
+func countPath(reqs []*http.Request, path string) int {
+	n := 0
+	for _, r := range reqs {
+		if r.URL.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSyncIDs_CheckpointWithoutResumeStartsAfresh(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+
+	f, err := ioutil.TempFile("", "checkpoint")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("{\"op\":\"create\",\"id\":\"UUID-1\"}\n")
+	f.Close()
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1"},
+		DestIDsRetriever:   staticIDListRetriever{},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+		Checkpoint:         f.Name(),
+	}
+
+	_, err = SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-1"}, dest.puts())
+}
+
+func TestSyncIDs_ResumeRequiresCheckpoint(t *testing.T) {
+	_, err := SyncIDs(context.Background(), &SyncService{Resume: true})
+	assert.Error(t, err)
+}
+
+func TestCheckpoint_LoadsLongPlan(t *testing.T) {
+	f, err := ioutil.TempFile("", "checkpoint")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.Close()
+
+	plan := &SyncPlan{Create: []string{}, Update: []string{}, Delete: []string{}}
+	for i := 0; i < 10000; i++ {
+		plan.Create = append(plan.Create, fmt.Sprintf("UUID-%05d", i))
+	}
+	journal, err := openCheckpoint(f.Name(), false, log.StandardLogger())
+	assert.NoError(t, err)
+	assert.NoError(t, journal.recordPlan(plannedSync{Plan: plan}))
+	assert.NoError(t, journal.record(opCreate, "UUID-00000"))
+	journal.Close()
+
+	journal, err = openCheckpoint(f.Name(), true, log.StandardLogger())
+	assert.NoError(t, err)
+	defer journal.Close()
+	assert.Equal(t, plan, journal.loadedPlan().Plan)
+	assert.True(t, journal.completed(opCreate, "UUID-00000"))
+}
+
+func TestSyncIDs_DryRunLeavesCheckpointAlone(t *testing.T) {
+	source := newFakeCollection(map[string]string{"UUID-1": `{"id":"UUID-1"}`})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+
+	f, err := ioutil.TempFile("", "checkpoint")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	entry := "{\"op\":\"create\",\"id\":\"UUID-1\"}\n"
+	f.WriteString(entry)
+	f.Close()
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1"},
+		DestIDsRetriever:   staticIDListRetriever{},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+		Checkpoint:         f.Name(),
+		DryRun:             true,
+		PlanOutput:         ioutil.Discard,
+	}
+	_, err = SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, entry, string(data))
+}
@@ -0,0 +1,223 @@
+package restutil
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Client makes the requests of the commands. Each client has its own HTTP client, authenticators, user agent, retry
+// policy, rate limiter, logger and transfer counts, so that several jobs can run in one process without interfering.
+type Client struct {
+	http      *http.Client
+	userAgent string
+	// retry is nil to follow DefaultRetryPolicy
+	retry       *RetryPolicy
+	limiter     *RateLimiter
+	log         *log.Logger
+	auth        *authRegistry
+	idLists     *idListRegistry
+	transferred transferCounts
+	// drain and progressEvery are nil to follow DrainTimeout and ProgressInterval
+	drain         *time.Duration
+	progressEvery *time.Duration
+}
+
+// transferCounts counts the retries and the body bytes of every request made by a client.
+type transferCounts struct {
+	retries int64
+	bytes   int64
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient makes requests with a copy of hc, keeping its timeout, cookie jar and redirect policy, and sending
+// requests through its Transport, or http.DefaultTransport when nil.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		cp := *hc
+		c.http = &cp
+	}
+}
+
+// WithAuthenticator registers auth for every request made to a URL under baseURL, as SetAuthenticator does.
+func WithAuthenticator(baseURL string, auth Authenticator) Option {
+	return func(c *Client) {
+		c.SetAuthenticator(baseURL, auth)
+	}
+}
+
+// WithIDList describes how the collections under baseURL list their ids, as SetIDList does.
+func WithIDList(baseURL string, l *IDList) Option {
+	return func(c *Client) {
+		c.SetIDList(baseURL, l)
+	}
+}
+
+// WithUserAgent sets the User-Agent header of every request, Useragent by default.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithRetryPolicy retries failed requests according to policy, rather than DefaultRetryPolicy. An empty policy never
+// retries.
+func WithRetryPolicy(policy *RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithDrainTimeout gives the requests in flight timeout to complete once the context of a run is cancelled, rather
+// than DrainTimeout.
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.drain = &timeout
+	}
+}
+
+// WithProgressInterval logs the progress of a run every interval when stderr is not a terminal, rather than every
+// ProgressInterval.
+func WithProgressInterval(interval time.Duration) Option {
+	return func(c *Client) {
+		c.progressEvery = &interval
+	}
+}
+
+// WithRateLimiter limits the rate of the requests a command is not given a limiter for.
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(c *Client) {
+		c.limiter = limiter
+	}
+}
+
+// WithLogger logs to logger, rather than the standard logger of logrus.
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Client) {
+		c.log = logger
+	}
+}
+
+// NewClient returns a client configured by opts. By default it has a transport of its own, retries according to
+// DefaultRetryPolicy, does not limit the rate of requests and logs to the standard logger.
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		http:      &http.Client{Transport: NewTransport()},
+		userAgent: Useragent,
+		log:       log.StandardLogger(),
+		auth:      newAuthRegistry(),
+		idLists:   newIDListRegistry(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	next := c.http.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c.http.Transport = &retryTransport{client: c, next: &limitTransport{client: c, next: &authTransport{client: c, next: &countTransport{counts: &c.transferred, next: &metricsTransport{next: next}}}}}
+	return c
+}
+
+// NewTransport returns a transport like the one a client has by default, to be changed and given to WithHTTPClient,
+// e.g. to dial through a proxy.
+func NewTransport() *http.Transport {
+	return &http.Transport{
+		MaxIdleConnsPerHost: 128,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+	}
+}
+
+var (
+	// Transport is the transport of DefaultClient, which may be changed before its first request, e.g. to dial
+	// through a proxy.
+	Transport = NewTransport()
+
+	// DefaultClient is the client used by the package level functions.
+	DefaultClient = NewClient(WithHTTPClient(&http.Client{Transport: Transport}))
+
+	// HttpClient makes requests the way DefaultClient does.
+	HttpClient = DefaultClient.http
+)
+
+// SetAuthenticator registers auth for every request made by the client to a URL under baseURL, or removes the one
+// registered when auth is nil. When several base URLs match a request, the longest one is used.
+func (c *Client) SetAuthenticator(baseURL string, auth Authenticator) {
+	c.auth.set(baseURL, auth)
+}
+
+// SetIDList describes how the collections under baseURL list their ids, or removes the description when l is nil. When
+// several base URLs match a collection, the longest one is used.
+func (c *Client) SetIDList(baseURL string, l *IDList) {
+	c.idLists.set(baseURL, l)
+}
+
+// retryPolicy returns the policy retrying the failed requests of the client.
+func (c *Client) retryPolicy() *RetryPolicy {
+	if c.retry != nil {
+		return c.retry
+	}
+	return DefaultRetryPolicy
+}
+
+// drainTimeout returns how long the requests in flight are given to complete once the context of a run is cancelled.
+func (c *Client) drainTimeout() time.Duration {
+	if c.drain != nil {
+		return *c.drain
+	}
+	return DrainTimeout
+}
+
+// progressInterval returns how often the progress of a run is logged when stderr is not a terminal.
+func (c *Client) progressInterval() time.Duration {
+	if c.progressEvery != nil {
+		return *c.progressEvery
+	}
+	return ProgressInterval
+}
+
+type attemptsKey struct{}
+
+// send makes req with the User-Agent of the client, and returns the number of attempts it took along with the outcome
+// of the last.
+func (c *Client) send(req *http.Request) (*http.Response, int, error) {
+	var attempts int32
+	req.Header.Set("User-Agent", c.userAgent)
+	resp, err := c.http.Do(req.WithContext(context.WithValue(req.Context(), attemptsKey{}, &attempts)))
+	return resp, int(atomic.LoadInt32(&attempts)), err
+}
+
+// countAttempt counts an attempt of req towards the total returned by send.
+func countAttempt(req *http.Request) {
+	if attempts, ok := req.Context().Value(attemptsKey{}).(*int32); ok {
+		atomic.AddInt32(attempts, 1)
+	}
+}
+
+// drainContext returns the context of the requests of a run stopping when ctx is done. It is only cancelled the drain
+// timeout of the client after ctx, so that the requests in flight can complete, or when cancel is called.
+func (c *Client) drainContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	reqCtx, cancel := context.WithCancel(context.Background())
+	timeout := c.drainTimeout()
+	go func() {
+		select {
+		case <-ctx.Done():
+			select {
+			case <-time.After(timeout):
+				c.log.Warnf("Requests still in flight after %v, cancelling them", timeout)
+				cancel()
+			case <-reqCtx.Done():
+			}
+		case <-reqCtx.Done():
+		}
+	}()
+	return reqCtx, cancel
+}
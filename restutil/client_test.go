@@ -0,0 +1,84 @@
+package restutil
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClient_ClientsDoNotInterfere(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2"}`,
+	})
+	defer source.Close()
+
+	first := NewClient(WithUserAgent("first"), WithAuthenticator(source.URL, HeaderAuth("X-Api-Key", "first")))
+	second := NewClient(WithUserAgent("second"), WithAuthenticator(source.URL, HeaderAuth("X-Api-Key", "second")))
+
+	out := new(bytes.Buffer)
+	summary, err := first.GetAllRest(context.Background(), source.URL, nil, nil, out)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), summary.Written)
+	_, err = second.doGet(context.Background(), source.URL, "UUID-1")
+	assert.NoError(t, err)
+	assert.Equal(t, summary.Bytes, first.newSummary().bytes)
+	assert.Equal(t, int64(len(`{"id":"UUID-1"}`)), second.newSummary().bytes)
+
+	reqs := source.requests("GET")
+	assert.Equal(t, 4, len(reqs))
+	for _, req := range reqs[:3] {
+		assert.Equal(t, "first", req.Header.Get("User-Agent"))
+		assert.Equal(t, "first", req.Header.Get("X-Api-Key"))
+	}
+	assert.Equal(t, "second", reqs[3].Header.Get("User-Agent"))
+	assert.Equal(t, "second", reqs[3].Header.Get("X-Api-Key"))
+}
+
+func TestClient_RetriesWithItsPolicy(t *testing.T) {
+	source := newFakeCollection(map[string]string{"UUID-1": `{"id":"UUID-1"}`})
+	defer source.Close()
+	source.fail("GET", "UUID-1", 1)
+
+	_, err := NewClient(WithRetryPolicy(&RetryPolicy{})).doGet(context.Background(), source.URL, "UUID-1")
+	assert.Error(t, err)
+
+	retrying := NewClient(WithRetryPolicy(&RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, RetryableStatus: []int{http.StatusServiceUnavailable}}))
+	body, err := retrying.doGet(context.Background(), source.URL, "UUID-1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"UUID-1"}`, string(body))
+}
+
+func TestClient_WithHTTPClient(t *testing.T) {
+	source := newFakeCollection(map[string]string{"UUID-1": `{"id":"UUID-1"}`})
+	defer source.Close()
+
+	hc := &http.Client{Timeout: time.Minute}
+	c := NewClient(WithHTTPClient(hc))
+	_, err := c.doGet(context.Background(), source.URL, "UUID-1")
+	assert.NoError(t, err)
+	assert.Nil(t, hc.Transport)
+	assert.Equal(t, time.Minute, c.http.Timeout)
+}
+
+func TestClient_DrainsWithItsTimeout(t *testing.T) {
+	short := NewClient(WithDrainTimeout(10 * time.Millisecond))
+	long := NewClient(WithDrainTimeout(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	shortCtx, shortCancel := short.drainContext(ctx)
+	defer shortCancel()
+	longCtx, longCancel := long.drainContext(ctx)
+	defer longCancel()
+	cancel()
+
+	select {
+	case <-shortCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("requests not cancelled after the drain timeout")
+	}
+	assert.NoError(t, longCtx.Err())
+}
@@ -0,0 +1,59 @@
+package restutil
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCompress_DecompressDetectsCompression(t *testing.T) {
+	for _, compression := range []string{Gzip, Zstd, ""} {
+		buf := new(bytes.Buffer)
+		w, err := Compress(buf, compression)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(`{"id":"UUID-1"}`))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+
+		r, err := Decompress(buf)
+		assert.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, `{"id":"UUID-1"}`, string(data), compression)
+	}
+
+	_, err := Compress(ioutil.Discard, "lz4")
+	assert.Error(t, err)
+}
+
+func TestCompressionFor(t *testing.T) {
+	assert.Equal(t, Gzip, CompressionFor("dump.jsonl.gz"))
+	assert.Equal(t, Zstd, CompressionFor("dump.jsonl.zst"))
+	assert.Equal(t, "", CompressionFor("dump.jsonl"))
+}
+
+func TestFileBasedIDListRetriever_ReadsCompressedFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "ids")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	w, err := Compress(f, Zstd)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("8f9b8a5e-4b4e-4b4e-8b4e-4b4e4b4e4b4e\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.NoError(t, f.Close())
+
+	ids := make(chan string)
+	errs := make(chan error, 1)
+	go newFileBasedIDListRetriever(f.Name()).Retrieve(context.Background(), ids, errs)
+	var read []string
+	for id := range ids {
+		read = append(read, id)
+	}
+	assert.Equal(t, []string{"8f9b8a5e-4b4e-4b4e-8b4e-4b4e4b4e4b4e"}, read)
+	assert.Equal(t, 0, len(errs))
+}
@@ -0,0 +1,233 @@
+package restutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type fieldChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+type resourceDiff struct {
+	ID      string        `json:"id"`
+	Changes []fieldChange `json:"changes"`
+}
+
+// DiffResources compares the content of every resource present in both collections with DefaultClient.
+func DiffResources(ctx context.Context, sourceURL, destURL string, ignore []string, conns int, failures *FailureReport, out io.Writer) (*Summary, error) {
+	return DefaultClient.DiffResources(ctx, sourceURL, destURL, ignore, conns, failures, out)
+}
+
+// DiffResources compares the content of every resource present in both collections and writes one JSON line per
+// differing resource to out. Old values are taken from the destination and new values from the source.
+//
+// Fields listed in ignore are skipped. An entry starting with "$" is matched against the full JSON path of a field
+// (e.g. "$.meta.lastModified"), any other entry is matched against field names at any depth.
+//
+// Resources that cannot be read are recorded in failures when given, rather than failing the whole diff. The diff stops
+// once the resources in flight are compared when ctx is done.
+func (c *Client) DiffResources(ctx context.Context, sourceURL, destURL string, ignore []string, conns int, failures *FailureReport, out io.Writer) (*Summary, error) {
+	summary := c.newSummary()
+	reqCtx, cancel := c.drainContext(ctx)
+	defer cancel()
+	sources, dests, err := c.fetchIDSets(ctx, sourceURL, destURL)
+	if err != nil {
+		return summary.finish(), err
+	}
+	ids := []string{}
+	for s := range sources {
+		if _, found := dests[s]; found {
+			ids = append(ids, s)
+		}
+	}
+	prog := c.newProgress(opDiff, len(ids))
+	defer prog.finish("Done diffs")
+	// stop is called when a resource fails without a failure report, or the diffs cannot be written, so that the
+	// workers stop too
+	workCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	shared := make(chan string, conns*BufferSize)
+	diffs := make(chan *resourceDiff, conns)
+	errs := make(chan error, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range shared {
+				if workCtx.Err() != nil {
+					continue
+				}
+				d, err := c.diffResource(reqCtx, sourceURL, destURL, id, ignore)
+				prog.increment()
+				if err != nil {
+					summary.addFailed(opDiff)
+					c.log.Errorf("Failed to diff ID=%v, Error=%v", id, err.Error())
+					if failures != nil {
+						err = failures.Report(opDiff, id, err)
+					} else {
+						err = &ResourceError{Op: opDiff, ID: id, Err: err}
+					}
+					if err == nil {
+						continue
+					}
+					select {
+					case errs <- err:
+					default:
+					}
+					stop()
+					continue
+				}
+				summary.addRead(2)
+				summary.addOp(opDiff)
+				if len(d.Changes) > 0 {
+					diffs <- d
+				} else {
+					summary.addSkipped(1)
+				}
+			}
+		}()
+	}
+
+	go func() {
+	feed:
+		for _, id := range ids {
+			select {
+			case shared <- id:
+			case <-workCtx.Done():
+				break feed
+			}
+		}
+		close(shared)
+		wg.Wait()
+		close(diffs)
+	}()
+
+	enc := json.NewEncoder(out)
+	for d := range diffs {
+		if err := enc.Encode(d); err != nil {
+			stop()
+			for range diffs {
+			}
+			return summary.finish(), err
+		}
+		summary.addWritten(1)
+	}
+
+	select {
+	case err := <-errs:
+		return summary.finish(), err
+	default:
+		return summary.finish(), ctx.Err()
+	}
+}
+
+func (c *Client) diffResource(ctx context.Context, sourceURL, destURL, id string, ignore []string) (*resourceDiff, error) {
+	source, err := c.doGet(ctx, sourceURL, id)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := c.doGet(ctx, destURL, id)
+	if err != nil {
+		return nil, err
+	}
+
+	newValue, err := decodeJSON(source)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding source resource %s: %v", id, err)
+	}
+	oldValue, err := decodeJSON(dest)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding destination resource %s: %v", id, err)
+	}
+
+	d := &resourceDiff{ID: id, Changes: []fieldChange{}}
+	diffValues("$", "", oldValue, newValue, ignore, &d.Changes)
+	return d, nil
+}
+
+// decodeJSON decodes a resource keeping its numbers as json.Number, so that large integers are compared exactly.
+func decodeJSON(data []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+func diffValues(path string, name string, oldValue, newValue interface{}, ignore []string, changes *[]fieldChange) {
+	if ignored(path, name, ignore) {
+		return
+	}
+
+	switch o := oldValue.(type) {
+	case map[string]interface{}:
+		if n, ok := newValue.(map[string]interface{}); ok {
+			keys := make(map[string]struct{})
+			for k := range o {
+				keys[k] = struct{}{}
+			}
+			for k := range n {
+				keys[k] = struct{}{}
+			}
+			sorted := make([]string, 0, len(keys))
+			for k := range keys {
+				sorted = append(sorted, k)
+			}
+			sort.Strings(sorted)
+			for _, k := range sorted {
+				diffValues(path+"."+k, k, o[k], n[k], ignore, changes)
+			}
+			return
+		}
+	case []interface{}:
+		if n, ok := newValue.([]interface{}); ok {
+			for i := 0; i < len(o) || i < len(n); i++ {
+				var ov, nv interface{}
+				if i < len(o) {
+					ov = o[i]
+				}
+				if i < len(n) {
+					nv = n[i]
+				}
+				diffValues(fmt.Sprintf("%s[%d]", path, i), name, ov, nv, ignore, changes)
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(oldValue, newValue) {
+		*changes = append(*changes, fieldChange{Path: path, Old: oldValue, New: newValue})
+	}
+}
+
+func ignored(path string, name string, ignore []string) bool {
+	for _, i := range ignore {
+		if strings.HasPrefix(i, "$") {
+			if i == path {
+				return true
+			}
+		} else if name != "" && i == name {
+			return true
+		}
+	}
+	return false
+}
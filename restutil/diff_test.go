@@ -0,0 +1,174 @@
+package restutil
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiffValues_ReportsChangedFields(t *testing.T) {
+	var changes []fieldChange
+	oldValue := map[string]interface{}{
+		"name": "old",
+		"same": 1.0,
+		"tags": []interface{}{"a", "b"},
+		"gone": true,
+	}
+	newValue := map[string]interface{}{
+		"name":  "new",
+		"same":  1.0,
+		"tags":  []interface{}{"a", "c", "d"},
+		"added": map[string]interface{}{"x": 1.0},
+	}
+
+	diffValues("$", "", oldValue, newValue, nil, &changes)
+
+	assert.Equal(t, []fieldChange{
+		{Path: "$.added", Old: nil, New: map[string]interface{}{"x": 1.0}},
+		{Path: "$.gone", Old: true, New: nil},
+		{Path: "$.name", Old: "old", New: "new"},
+		{Path: "$.tags[1]", Old: "b", New: "c"},
+		{Path: "$.tags[2]", Old: nil, New: "d"},
+	}, changes)
+}
+
+func TestDiffValues_IgnoresFields(t *testing.T) {
+	var changes []fieldChange
+	oldValue := map[string]interface{}{
+		"lastModified": "2016-01-01",
+		"meta":         map[string]interface{}{"publishedDate": "2016-01-01", "lastModified": "2016-01-01"},
+		"name":         "same",
+	}
+	newValue := map[string]interface{}{
+		"lastModified": "2016-02-02",
+		"meta":         map[string]interface{}{"publishedDate": "2016-02-02", "lastModified": "2016-02-02"},
+		"name":         "same",
+	}
+
+	diffValues("$", "", oldValue, newValue, []string{"lastModified", "$.meta.publishedDate"}, &changes)
+
+	assert.Empty(t, changes)
+}
+
+func TestDiffResources_OnlyComparesSharedIDs(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1","name":"same"}`,
+		"UUID-2": `{"id":"UUID-2","name":"new"}`,
+		"UUID-3": `{"id":"UUID-3"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{
+		"UUID-1": `{"name":"same","id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2","name":"old"}`,
+		"UUID-4": `{"id":"UUID-4"}`,
+	})
+	defer dest.Close()
+
+	out := new(bytes.Buffer)
+	_, err := DiffResources(context.Background(), source.URL, dest.URL, nil, 2, nil, out)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(source.requests("GET")))
+	assert.Equal(t, 3, len(dest.requests("GET")))
+	assert.JSONEq(t, `{"id":"UUID-2","changes":[{"path":"$.name","old":"old","new":"new"}]}`, out.String())
+}
+
+func TestDiffResources_CancelStartsNoNewRequests(t *testing.T) {
+	resources := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		resources[fmt.Sprintf("UUID-%03d", i)] = `{}`
+	}
+	source := newFakeCollection(resources)
+	defer source.Close()
+	dest := newFakeCollection(resources)
+	defer dest.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	gets := 0
+	cancelling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/__ids" {
+			mu.Lock()
+			if gets++; gets == 5 {
+				cancel()
+			}
+			mu.Unlock()
+		}
+		source.handle(w, r)
+	}))
+	defer cancelling.Close()
+
+	conns := 2
+	_, err := DiffResources(ctx, cancelling.URL, dest.URL, nil, conns, nil, new(bytes.Buffer))
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, len(source.requests("GET")) <= 1+5+conns, "made %d source GETs after cancelling", len(source.requests("GET")))
+}
+
+func TestDiffResources_StopsAtFirstFailureWithoutReport(t *testing.T) {
+	resources := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		resources[fmt.Sprintf("UUID-%03d", i)] = `{}`
+	}
+	source := newFakeCollection(resources)
+	defer source.Close()
+	dest := newFakeCollection(resources)
+	defer dest.Close()
+	for id := range resources {
+		dest.fail("GET", id, 1)
+	}
+
+	conns := 2
+	_, err := NewClient(WithRetryPolicy(&RetryPolicy{})).DiffResources(context.Background(), source.URL, dest.URL, nil, conns, nil, new(bytes.Buffer))
+	assert.IsType(t, &ResourceError{}, err)
+	assert.True(t, len(dest.requests("GET")) <= 1+2*conns, "made %d destination GETs after the first failure", len(dest.requests("GET")))
+}
+
+func TestDiffResources_ComparesLargeIntegersExactly(t *testing.T) {
+	source := newFakeCollection(map[string]string{"UUID-1": `{"id":"UUID-1","version":9007199254740993}`})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{"UUID-1": `{"id":"UUID-1","version":9007199254740992}`})
+	defer dest.Close()
+
+	out := new(bytes.Buffer)
+	_, err := DiffResources(context.Background(), source.URL, dest.URL, nil, 1, nil, out)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"UUID-1","changes":[{"path":"$.version","old":9007199254740992,"new":9007199254740993}]}`+"\n", out.String())
+}
+
+func TestDiffResources_WriteErrorStopsDiffing(t *testing.T) {
+	sources := make(map[string]string)
+	dests := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		sources[fmt.Sprintf("UUID-%03d", i)] = `{"name":"new"}`
+		dests[fmt.Sprintf("UUID-%03d", i)] = `{"name":"old"}`
+	}
+	source := newFakeCollection(sources)
+	defer source.Close()
+	dest := newFakeCollection(dests)
+	defer dest.Close()
+
+	conns := 2
+	_, err := DiffResources(context.Background(), source.URL, dest.URL, nil, conns, nil, failingWriter{})
+	assert.EqualError(t, err, "disk full")
+	assert.True(t, len(source.requests("GET")) < 1+len(sources), "made %d source GETs after failing to write", len(source.requests("GET")))
+	assert.True(t, goroutinesStop("DiffResources.func"), "workers still running after DiffResources returned")
+}
+
+// goroutinesStop tells whether the goroutines running a function whose name contains fn all exit within a second.
+func goroutinesStop(fn string) bool {
+	buf := make([]byte, 1<<20)
+	for i := 0; i < 100; i++ {
+		if !strings.Contains(string(buf[:runtime.Stack(buf, true)]), fn) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package restutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// bodyExcerptSize is how much of a failed response body is kept for the failure report.
+const bodyExcerptSize = 512
+
+// RequestError is returned when a request fails, either with a network error or with an unexpected status.
+type RequestError struct {
+	// Message describes what was being done, e.g. "error copying resource".
+	Message string
+	Method  string
+	URL     string
+	// StatusCode is zero when no response was received.
+	StatusCode int
+	// Body is an excerpt of the response body.
+	Body string
+	// Attempts counts the first attempt and every retry.
+	Attempts int
+	Err      error
+}
+
+func (e *RequestError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %d %s", e.Message, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// IDListError is returned when the ids of a collection cannot be listed from its __ids resource.
+type IDListError struct {
+	URL string
+	Err error
+}
+
+func (e *IDListError) Error() string {
+	return fmt.Sprintf("error listing ids at %s: %s", e.URL, e.Err)
+}
+
+func (e *IDListError) Unwrap() error {
+	return e.Err
+}
+
+// ResourceError is returned when an operation fails on a resource, and no failure report was given to record it in.
+// Err is often a *RequestError.
+type ResourceError struct {
+	Op  string
+	ID  string
+	Err error
+}
+
+func (e *ResourceError) Error() string {
+	return fmt.Sprintf("failed to %s ID=%s: %s", e.Op, e.ID, e.Err)
+}
+
+func (e *ResourceError) Unwrap() error {
+	return e.Err
+}
+
+// newRequestError describes req failing with err, or with resp when err is nil. It reads an excerpt of the response
+// body, but leaves closing it to the caller.
+func newRequestError(message string, req *http.Request, resp *http.Response, attempts int, err error) *RequestError {
+	e := &RequestError{
+		Message:  message,
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Attempts: attempts,
+		Err:      err,
+	}
+	if err == nil && resp != nil {
+		e.StatusCode = resp.StatusCode
+		excerpt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, bodyExcerptSize))
+		e.Body = string(excerpt)
+	}
+	return e
+}
+
+// Failure is an entry of a failure report.
+type Failure struct {
+	ID       string    `json:"id"`
+	Op       string    `json:"op"`
+	URL      string    `json:"url,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	Body     string    `json:"body,omitempty"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts,omitempty"`
+	Time     time.Time `json:"timestamp"`
+	// Resource is the JSON body that failed to be PUT, so that the PUT can be replayed.
+	Resource json.RawMessage `json:"resource,omitempty"`
+}
+
+// FailureReport records failed operations as JSON lines, so they can be analysed and replayed. Commands given a
+// report carry on past failures rather than stopping at the first.
+//
+// A nil *FailureReport records nothing.
+type FailureReport struct {
+	mu    sync.Mutex
+	f     *os.File
+	enc   *json.Encoder
+	count int
+}
+
+// CreateFailureReport creates, or truncates, the report file at path.
+func CreateFailureReport(path string) (*FailureReport, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating failure report file=%s: %s", path, err)
+	}
+	return &FailureReport{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Report records that op failed on the resource id with err. Details of the request are included when err is a
+// *RequestError.
+func (r *FailureReport) Report(op string, id string, err error) error {
+	return r.reportResource(op, id, nil, err)
+}
+
+// reportResource records a failure along with the resource that was being written.
+func (r *FailureReport) reportResource(op string, id string, resource []byte, err error) error {
+	if r == nil {
+		return nil
+	}
+	f := Failure{ID: id, Op: op, Error: err.Error(), Time: time.Now().UTC(), Resource: resource}
+	if re, ok := err.(*RequestError); ok {
+		f.URL = re.URL
+		f.Status = re.StatusCode
+		f.Body = re.Body
+		f.Attempts = re.Attempts
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	if err := r.enc.Encode(f); err != nil {
+		return fmt.Errorf("error writing failure report: %s", err)
+	}
+	return nil
+}
+
+// reportFailure records a failure where an error writing the report cannot be returned, so it is logged instead.
+func (c *Client) reportFailure(r *FailureReport, op string, id string, err error) {
+	if err := r.Report(op, id, err); err != nil {
+		c.log.Error(err)
+	}
+}
+
+// Count returns the number of failures reported so far.
+func (r *FailureReport) Count() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count
+}
+
+func (r *FailureReport) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.f.Close()
+}
@@ -0,0 +1,107 @@
+package restutil
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFailures(t *testing.T, path string) []Failure {
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var failures []Failure
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var failure Failure
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &failure))
+		failures = append(failures, failure)
+	}
+	return failures
+}
+
+func TestSyncIDs_FailureReportCarriesOnPastFailures(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, RetryableStatus: []int{http.StatusServiceUnavailable}}
+
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2"}`,
+		"UUID-3": `{"id":"UUID-3"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{
+		"UUID-4": `{"id":"UUID-4"}`,
+	})
+	defer dest.Close()
+	dest.fail("PUT", "UUID-2", 2)
+	dest.fail("DELETE", "UUID-4", 2)
+
+	f, err := ioutil.TempFile("", "failures")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+	report, err := CreateFailureReport(f.Name())
+	assert.NoError(t, err)
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1", "UUID-2", "UUID-3"},
+		DestIDsRetriever:   staticIDListRetriever{"UUID-4"},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+		Deletes:            true,
+		Failures:           report,
+	}
+
+	_, err = SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.NoError(t, report.Close())
+	assert.Equal(t, 2, report.Count())
+	assert.Equal(t, []string{"UUID-1", "UUID-3"}, dest.puts())
+
+	failures := readFailures(t, f.Name())
+	if assert.Equal(t, 2, len(failures)) {
+		assert.Equal(t, "UUID-2", failures[0].ID)
+		assert.Equal(t, opCreate, failures[0].Op)
+		assert.Equal(t, dest.URL+"/UUID-2", failures[0].URL)
+		assert.Equal(t, http.StatusServiceUnavailable, failures[0].Status)
+		assert.Equal(t, 2, failures[0].Attempts)
+		assert.True(t, strings.HasPrefix(failures[0].Error, "error copying resource: 503"))
+		assert.WithinDuration(t, time.Now(), failures[0].Time, time.Minute)
+
+		assert.Equal(t, "UUID-4", failures[1].ID)
+		assert.Equal(t, opDelete, failures[1].Op)
+	}
+}
+
+func TestFailureReport_ReportsNonRequestErrors(t *testing.T) {
+	f, err := ioutil.TempFile("", "failures")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+	report, err := CreateFailureReport(f.Name())
+	assert.NoError(t, err)
+
+	assert.NoError(t, report.Report(opPut, "UUID-1", assert.AnError))
+	assert.NoError(t, report.Close())
+
+	failures := readFailures(t, f.Name())
+	if assert.Equal(t, 1, len(failures)) {
+		assert.Equal(t, Failure{ID: "UUID-1", Op: opPut, Error: assert.AnError.Error(), Time: failures[0].Time}, failures[0])
+	}
+}
+
+func TestFailureReport_NilRecordsNothing(t *testing.T) {
+	var report *FailureReport
+	assert.NoError(t, report.Report(opPut, "UUID-1", assert.AnError))
+	assert.Equal(t, 0, report.Count())
+	assert.NoError(t, report.Close())
+}
@@ -0,0 +1,394 @@
+package restutil
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The formats of the __ids of a collection.
+const (
+	// IDsStream is a stream of JSON entries, such as {"id":"..."}{"id":"..."}.
+	IDsStream = "stream"
+	// IDsArray is a JSON array of entries, or a JSON object holding one in its ItemsField.
+	IDsArray = "array"
+	// IDsText is plain text, one id per line.
+	IDsText = "text"
+)
+
+// The ways the __ids of a collection may be paged.
+const (
+	// PageByLink follows the URL of the next page, given by the Link header with rel="next" or the NextField of a page.
+	PageByLink = "link"
+	// PageByCursor sends the cursor found in the NextField of a page to get the next one.
+	PageByCursor = "cursor"
+	// PageByNumber asks for pages 1, 2, 3... until one is empty or short.
+	PageByNumber = "page"
+	// PageByOffset asks for pages at offsets 0, PageSize, 2*PageSize... until one is empty or short.
+	PageByOffset = "offset"
+)
+
+// IDList describes how the __ids of a collection list its ids.
+//
+// A nil *IDList is a single stream of {"id":"..."} entries, as listed by most of our services.
+type IDList struct {
+	// Format is IDsStream, IDsArray or IDsText, IDsStream when empty.
+	Format string
+	// IDField names the field holding the id of a JSON entry, "id" when empty. Entries may also be plain strings.
+	IDField string
+	// ItemsField names the field holding the array of entries of a page, when pages are JSON objects such as
+	// {"items":[...],"next":"..."}. It only applies to IDsArray.
+	ItemsField string
+	// Paging is PageByLink, PageByCursor, PageByNumber or PageByOffset, or empty when every id comes in one response.
+	Paging string
+	// NextField names the field of a page object holding the URL of the next page for PageByLink, which otherwise
+	// follows the Link header, or the cursor of the next page for PageByCursor. It requires ItemsField.
+	NextField string
+	// Param names the query parameter carrying the cursor, page number or offset, the name of the paging when empty.
+	Param string
+	// PageSize, when set, is sent in the LimitParam of every page. PageByOffset requires it.
+	PageSize int
+	// LimitParam names the query parameter carrying PageSize, "limit" when empty.
+	LimitParam string
+}
+
+// Validate returns an error if l is inconsistent, such as cursor paging without the field holding the next cursor.
+func (l *IDList) Validate() error {
+	if l == nil {
+		return nil
+	}
+	switch l.Format {
+	case "", IDsStream, IDsArray, IDsText:
+	default:
+		return fmt.Errorf("unknown ids format %q", l.Format)
+	}
+	switch l.Paging {
+	case "", PageByLink, PageByNumber:
+	case PageByCursor:
+		if l.NextField == "" {
+			return errors.New("cursor paging requires the field holding the next cursor")
+		}
+	case PageByOffset:
+		if l.PageSize <= 0 {
+			return errors.New("offset paging requires a page size")
+		}
+	default:
+		return fmt.Errorf("unknown ids paging %q", l.Paging)
+	}
+	if l.ItemsField != "" && l.Format != IDsArray {
+		return errors.New("the field holding the ids only applies to the array format")
+	}
+	if l.NextField != "" && l.ItemsField == "" {
+		return errors.New("the field holding the next page requires the field holding the ids")
+	}
+	return nil
+}
+
+func (l *IDList) format() string {
+	if l == nil || l.Format == "" {
+		return IDsStream
+	}
+	return l.Format
+}
+
+func (l *IDList) paging() string {
+	if l == nil {
+		return ""
+	}
+	return l.Paging
+}
+
+func (l *IDList) idField() string {
+	if l == nil || l.IDField == "" {
+		return "id"
+	}
+	return l.IDField
+}
+
+func (l *IDList) param() string {
+	if l.Param == "" {
+		return l.Paging
+	}
+	return l.Param
+}
+
+func (l *IDList) limitParam() string {
+	if l.LimitParam == "" {
+		return "limit"
+	}
+	return l.LimitParam
+}
+
+// listIDs calls fn for every id listed from the __ids at u, page after page, stopping at the first error. get makes the
+// request of a page, and returns its response once known to be successful.
+func (l *IDList) listIDs(u *url.URL, get func(u *url.URL) (*http.Response, error), fn func(id string) error) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	pageURL := u
+	for page := 0; pageURL != nil; page++ {
+		if (page == 0 && l.paging() != "") || l.paging() == PageByNumber || l.paging() == PageByOffset {
+			pageURL = l.pageURL(u, page, "")
+		}
+		resp, err := get(pageURL)
+		if err != nil {
+			return err
+		}
+		n, next, err := l.readPage(resp.Body, fn)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		pageURL, err = l.nextURL(u, pageURL, resp, n, next)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageURL returns the URL of a page numbered from zero, or of the page of a cursor.
+func (l *IDList) pageURL(u *url.URL, page int, cursor string) *url.URL {
+	p := *u
+	q := p.Query()
+	switch l.Paging {
+	case PageByNumber:
+		q.Set(l.param(), strconv.Itoa(page+1))
+	case PageByOffset:
+		q.Set(l.param(), strconv.Itoa(page*l.PageSize))
+	case PageByCursor:
+		if cursor != "" {
+			q.Set(l.param(), cursor)
+		}
+	}
+	if l.PageSize > 0 {
+		q.Set(l.limitParam(), strconv.Itoa(l.PageSize))
+	}
+	p.RawQuery = q.Encode()
+	return &p
+}
+
+// nextURL returns the URL of the page following the one at pageURL, which listed n ids and the next value of its
+// NextField, or nil after the last page.
+func (l *IDList) nextURL(u *url.URL, pageURL *url.URL, resp *http.Response, n int, next string) (*url.URL, error) {
+	switch l.paging() {
+	case PageByLink:
+		if next == "" {
+			next = linkNext(resp.Header)
+		}
+		if next == "" {
+			return nil, nil
+		}
+		return pageURL.Parse(next)
+	case PageByCursor:
+		if next == "" || n == 0 {
+			return nil, nil
+		}
+		return l.pageURL(u, 0, next), nil
+	case PageByNumber, PageByOffset:
+		if n == 0 || (l.PageSize > 0 && n < l.PageSize) {
+			return nil, nil
+		}
+		return pageURL, nil
+	default:
+		return nil, nil
+	}
+}
+
+// linkNext returns the URL of the rel="next" link of a Link header, or "" if there is none.
+func linkNext(h http.Header) string {
+	for _, header := range h["Link"] {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(kv[0], "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(kv[1], `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return target[1 : len(target)-1]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// readPage calls fn for every id of a page, and returns how many it listed along with the value of its NextField.
+func (l *IDList) readPage(r io.Reader, fn func(id string) error) (int, string, error) {
+	n := 0
+	count := func(id string) error {
+		n++
+		return fn(id)
+	}
+	switch l.format() {
+	case IDsText:
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if id := strings.TrimSpace(scanner.Text()); id != "" {
+				if err := count(id); err != nil {
+					return n, "", err
+				}
+			}
+		}
+		return n, "", scanner.Err()
+	case IDsArray:
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		if l.ItemsField == "" {
+			err := l.readArray(dec, count)
+			return n, "", err
+		}
+		next, err := l.readObject(dec, count)
+		return n, next, err
+	default:
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		for {
+			var entry interface{}
+			if err := dec.Decode(&entry); err == io.EOF {
+				return n, "", nil
+			} else if err != nil {
+				return n, "", err
+			}
+			if err := l.readEntry(entry, count); err != nil {
+				return n, "", err
+			}
+		}
+	}
+}
+
+// readObject reads a page object, passing the entries of its ItemsField to fn and returning the value of its
+// NextField.
+func (l *IDList) readObject(dec *json.Decoder, fn func(id string) error) (string, error) {
+	if err := expectDelim(dec, '{'); err != nil {
+		return "", err
+	}
+	var next string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t {
+		case l.ItemsField:
+			if err := l.readArray(dec, fn); err != nil {
+				return "", err
+			}
+		case l.NextField:
+			var v interface{}
+			if err := dec.Decode(&v); err != nil {
+				return "", err
+			}
+			if v != nil {
+				next = fmt.Sprint(v)
+			}
+		default:
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return "", err
+			}
+		}
+	}
+	return next, expectDelim(dec, '}')
+}
+
+// readArray streams the entries of a JSON array to fn, so that a long list is not held in memory.
+func (l *IDList) readArray(dec *json.Decoder, fn func(id string) error) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	for dec.More() {
+		var entry interface{}
+		if err := dec.Decode(&entry); err != nil {
+			return err
+		}
+		if err := l.readEntry(entry, fn); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return fmt.Errorf("expected %v in ids, found %v", delim, t)
+	}
+	return nil
+}
+
+// readEntry passes the id of an entry to fn, the entry being either an object holding it in IDField or the id itself.
+func (l *IDList) readEntry(entry interface{}, fn func(id string) error) error {
+	switch e := entry.(type) {
+	case string:
+		return fn(e)
+	case json.Number:
+		return fn(e.String())
+	case map[string]interface{}:
+		switch id := e[l.idField()].(type) {
+		case string:
+			return fn(id)
+		case json.Number:
+			return fn(id.String())
+		}
+	}
+	return fmt.Errorf("no %s in ids entry %v", l.idField(), entry)
+}
+
+// idListRegistry holds the ID list formats of a client by base URL.
+type idListRegistry struct {
+	sync.RWMutex
+	byBaseURL map[string]*IDList
+}
+
+func newIDListRegistry() *idListRegistry {
+	return &idListRegistry{byBaseURL: make(map[string]*IDList)}
+}
+
+// SetIDList describes how the collections under baseURL list their ids for DefaultClient. When several base URLs
+// match a collection, the longest one is used.
+func SetIDList(baseURL string, l *IDList) {
+	DefaultClient.SetIDList(baseURL, l)
+}
+
+func (r *idListRegistry) set(baseURL string, l *IDList) {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL = baseURL + "/"
+	}
+	r.Lock()
+	defer r.Unlock()
+	if l == nil {
+		delete(r.byBaseURL, baseURL)
+		return
+	}
+	r.byBaseURL[baseURL] = l
+}
+
+func (r *idListRegistry) idListFor(u string) *IDList {
+	r.RLock()
+	defer r.RUnlock()
+	var match string
+	for baseURL := range r.byBaseURL {
+		if underBaseURL(u, baseURL) && len(baseURL) > len(match) {
+			match = baseURL
+		}
+	}
+	return r.byBaseURL[match]
+}
@@ -0,0 +1,232 @@
+package restutil
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// newPagedIDs serves the pages of the __ids of a collection, as written by page for the query of each request.
+func newPagedIDs(page func(w http.ResponseWriter, q url.Values)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/__ids" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		page(w, r.URL.Query())
+	}))
+}
+
+func listAll(t *testing.T, l *IDList, baseURL string) ([]string, error) {
+	u, err := url.Parse(baseURL + "/__ids")
+	assert.NoError(t, err)
+	var ids []string
+	err = l.listIDs(u, func(u *url.URL) (*http.Response, error) {
+		return http.Get(u.String())
+	}, func(id string) error {
+		ids = append(ids, id)
+		return nil
+	})
+	return ids, err
+}
+
+func TestIDList_Stream(t *testing.T) {
+	server := newPagedIDs(func(w http.ResponseWriter, q url.Values) {
+		fmt.Fprint(w, `{"id":"UUID-1"}`+"\n"+`{"id":"UUID-2"}`)
+	})
+	defer server.Close()
+
+	ids, err := listAll(t, nil, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-1", "UUID-2"}, ids)
+}
+
+func TestIDList_Array(t *testing.T) {
+	server := newPagedIDs(func(w http.ResponseWriter, q url.Values) {
+		fmt.Fprint(w, `[{"uuid":"UUID-1"},"UUID-2",3]`)
+	})
+	defer server.Close()
+
+	ids, err := listAll(t, &IDList{Format: IDsArray, IDField: "uuid"}, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-1", "UUID-2", "3"}, ids)
+}
+
+func TestIDList_Text(t *testing.T) {
+	server := newPagedIDs(func(w http.ResponseWriter, q url.Values) {
+		fmt.Fprint(w, "UUID-1\n\n  UUID-2  \nUUID-3")
+	})
+	defer server.Close()
+
+	ids, err := listAll(t, &IDList{Format: IDsText}, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-1", "UUID-2", "UUID-3"}, ids)
+}
+
+func TestIDList_PageByLinkHeader(t *testing.T) {
+	server := newPagedIDs(func(w http.ResponseWriter, q url.Values) {
+		switch q.Get("after") {
+		case "":
+			w.Header().Set("Link", `</__ids?after=UUID-2>; rel="next", </__ids>; rel="first"`)
+			fmt.Fprint(w, `{"id":"UUID-1"}{"id":"UUID-2"}`)
+		case "UUID-2":
+			fmt.Fprint(w, `{"id":"UUID-3"}`)
+		}
+	})
+	defer server.Close()
+
+	ids, err := listAll(t, &IDList{Paging: PageByLink}, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-1", "UUID-2", "UUID-3"}, ids)
+}
+
+func TestIDList_PageByNextField(t *testing.T) {
+	server := newPagedIDs(func(w http.ResponseWriter, q url.Values) {
+		switch q.Get("page") {
+		case "":
+			fmt.Fprint(w, `{"total":3,"items":[{"id":"UUID-1"},{"id":"UUID-2"}],"links":"/__ids?page=2"}`)
+		case "2":
+			fmt.Fprint(w, `{"total":3,"items":[{"id":"UUID-3"}],"links":null}`)
+		}
+	})
+	defer server.Close()
+
+	ids, err := listAll(t, &IDList{Format: IDsArray, ItemsField: "items", Paging: PageByLink, NextField: "links"}, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-1", "UUID-2", "UUID-3"}, ids)
+}
+
+func TestIDList_PageByCursor(t *testing.T) {
+	var limits []string
+	server := newPagedIDs(func(w http.ResponseWriter, q url.Values) {
+		limits = append(limits, q.Get("size"))
+		switch q.Get("cursor") {
+		case "":
+			fmt.Fprint(w, `{"data":["UUID-1","UUID-2"],"nextCursor":"abc"}`)
+		case "abc":
+			fmt.Fprint(w, `{"data":["UUID-3"],"nextCursor":""}`)
+		}
+	})
+	defer server.Close()
+
+	l := &IDList{Format: IDsArray, ItemsField: "data", Paging: PageByCursor, NextField: "nextCursor", PageSize: 2, LimitParam: "size"}
+	ids, err := listAll(t, l, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-1", "UUID-2", "UUID-3"}, ids)
+	assert.Equal(t, []string{"2", "2"}, limits)
+}
+
+func TestIDList_PageByNumber(t *testing.T) {
+	var pages []string
+	server := newPagedIDs(func(w http.ResponseWriter, q url.Values) {
+		pages = append(pages, q.Get("p"))
+		switch q.Get("p") {
+		case "1":
+			fmt.Fprint(w, "UUID-1\nUUID-2\n")
+		case "2":
+			fmt.Fprint(w, "UUID-3\nUUID-4\n")
+		}
+	})
+	defer server.Close()
+
+	ids, err := listAll(t, &IDList{Format: IDsText, Paging: PageByNumber, Param: "p"}, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-1", "UUID-2", "UUID-3", "UUID-4"}, ids)
+	assert.Equal(t, []string{"1", "2", "3"}, pages)
+}
+
+func TestIDList_PageByOffset(t *testing.T) {
+	all := []string{"UUID-1", "UUID-2", "UUID-3", "UUID-4", "UUID-5"}
+	var offsets []string
+	server := newPagedIDs(func(w http.ResponseWriter, q url.Values) {
+		offsets = append(offsets, q.Get("offset"))
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		for i := offset; i < offset+limit && i < len(all); i++ {
+			fmt.Fprintf(w, `{"id":"%s"}`, all[i])
+		}
+	})
+	defer server.Close()
+
+	ids, err := listAll(t, &IDList{Paging: PageByOffset, PageSize: 2}, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, all, ids)
+	assert.Equal(t, []string{"0", "2", "4"}, offsets)
+}
+
+func TestIDList_MissingIDField(t *testing.T) {
+	server := newPagedIDs(func(w http.ResponseWriter, q url.Values) {
+		fmt.Fprint(w, `[{"uuid":"UUID-1"}]`)
+	})
+	defer server.Close()
+
+	_, err := listAll(t, &IDList{Format: IDsArray}, server.URL)
+	assert.EqualError(t, err, "no id in ids entry map[uuid:UUID-1]")
+}
+
+func TestIDList_Validate(t *testing.T) {
+	assert.NoError(t, (*IDList)(nil).Validate())
+	assert.NoError(t, (&IDList{Format: IDsArray, ItemsField: "items", Paging: PageByCursor, NextField: "next"}).Validate())
+	assert.Error(t, (&IDList{Format: "csv"}).Validate())
+	assert.Error(t, (&IDList{Paging: "token"}).Validate())
+	assert.Error(t, (&IDList{Paging: PageByCursor}).Validate())
+	assert.Error(t, (&IDList{Paging: PageByOffset}).Validate())
+	assert.Error(t, (&IDList{ItemsField: "items"}).Validate())
+	assert.Error(t, (&IDList{Format: IDsArray, NextField: "next"}).Validate())
+}
+
+func TestLinkNext(t *testing.T) {
+	h := http.Header{}
+	assert.Equal(t, "", linkNext(h))
+	h.Add("Link", `<https://example.com/__ids?page=1>; rel="prev"`)
+	h.Add("Link", `<https://example.com/__ids?page=3>; rel="last", <https://example.com/__ids?page=2>; rel="next"`)
+	assert.Equal(t, "https://example.com/__ids?page=2", linkNext(h))
+}
+
+func TestClient_DiffIDsWithIDList(t *testing.T) {
+	source := newPagedIDs(func(w http.ResponseWriter, q url.Values) {
+		fmt.Fprint(w, "UUID-1\nUUID-2\n")
+	})
+	defer source.Close()
+	dest := newPagedIDs(func(w http.ResponseWriter, q url.Values) {
+		if q.Get("cursor") == "" {
+			fmt.Fprint(w, `{"items":["UUID-2"],"next":"c1"}`)
+			return
+		}
+		fmt.Fprint(w, `{"items":["UUID-3"]}`)
+	})
+	defer dest.Close()
+
+	c := NewClient(
+		WithIDList(source.URL, &IDList{Format: IDsText}),
+		WithIDList(dest.URL, &IDList{Format: IDsArray, ItemsField: "items", Paging: PageByCursor, NextField: "next"}),
+	)
+	out := new(bytes.Buffer)
+	_, err := c.DiffIDs(context.Background(), source.URL+"/", dest.URL+"/", out)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"only-in-source":["UUID-1"],"only-in-destination":["UUID-3"]}`, out.String())
+}
+
+func TestUrlBasedRetrieve_IDList(t *testing.T) {
+	server := newPagedIDs(func(w http.ResponseWriter, q url.Values) {
+		fmt.Fprint(w, `["UUID-1","UUID-2"]`)
+	})
+	defer server.Close()
+
+	c := NewClient(WithIDList(server.URL, &IDList{Format: IDsArray}))
+	ids := make(chan string)
+	errs := make(chan error, 1)
+	go c.IDListRetriever("", server.URL+"/").Retrieve(context.Background(), ids, errs)
+	var actual []string
+	for id := range ids {
+		actual = append(actual, id)
+	}
+	assert.Empty(t, errs)
+	assert.Equal(t, []string{"UUID-1", "UUID-2"}, actual)
+}
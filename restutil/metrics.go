@@ -0,0 +1,79 @@
+package restutil
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const metricsNamespace = "up_restutil"
+
+var (
+	// Metrics is the registry of the metrics of every request made by any Client, and of the items processed by
+	// the commands.
+	Metrics = prometheus.NewRegistry()
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Requests made, by method, response status and host. Every retry counts as a request.",
+	}, []string{"method", "status", "host"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time taken for a response to arrive, by method and host.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "host"})
+	requestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_in_flight",
+		Help:      "Requests waiting for a response.",
+	})
+	itemsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "items_processed_total",
+		Help:      "Resources processed successfully, by operation.",
+	}, []string{"op"})
+	itemsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "items_failed_total",
+		Help:      "Resources whose processing failed, by operation.",
+	}, []string{"op"})
+	rateLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limit",
+		Help:      "Current rate limit of the requests to a host, in requests per second.",
+	}, []string{"host"})
+)
+
+func init() {
+	Metrics.MustRegister(requestsTotal, requestDuration, requestsInFlight, itemsProcessed, itemsFailed, rateLimit)
+}
+
+// MetricsHandler serves the metrics in the Prometheus exposition format.
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(Metrics, promhttp.HandlerOpts{})
+}
+
+// metricsTransport records the metrics of every attempt of a request.
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestsInFlight.Inc()
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	requestsInFlight.Dec()
+
+	host := req.URL.Host
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+		requestDuration.WithLabelValues(req.Method, host).Observe(time.Since(start).Seconds())
+	}
+	requestsTotal.WithLabelValues(req.Method, status, host).Inc()
+	return resp, err
+}
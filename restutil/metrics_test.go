@@ -0,0 +1,72 @@
+package restutil
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMetrics_CountRequestsByStatusAndHost(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{}
+
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+	})
+	defer source.Close()
+	u, _ := url.Parse(source.URL)
+
+	ok := requestsTotal.WithLabelValues("GET", "200", u.Host)
+	notFound := requestsTotal.WithLabelValues("GET", "404", u.Host)
+	before := testutil.ToFloat64(ok)
+
+	_, err := DefaultClient.doGet(context.Background(), source.URL, "UUID-1")
+	assert.NoError(t, err)
+	_, err = DefaultClient.doGet(context.Background(), source.URL, "UUID-2")
+	assert.Error(t, err)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(ok))
+	assert.Equal(t, float64(1), testutil.ToFloat64(notFound))
+	assert.Equal(t, float64(0), testutil.ToFloat64(requestsInFlight))
+}
+
+func TestMetrics_CountItemsByOperation(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+
+	before := testutil.ToFloat64(itemsProcessed.WithLabelValues(opCreate))
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1", "UUID-2"},
+		DestIDsRetriever:   staticIDListRetriever{},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+	}
+	_, err := SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.Equal(t, before+2, testutil.ToFloat64(itemsProcessed.WithLabelValues(opCreate)))
+}
+
+func TestMetricsHandler_ServesMetrics(t *testing.T) {
+	rateLimit.WithLabelValues("example.com").Set(5)
+
+	server := httptest.NewServer(MetricsHandler())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(body), `up_restutil_rate_limit{host="example.com"} 5`), string(body))
+}
@@ -0,0 +1,133 @@
+package restutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type netrcMachine struct {
+	login    string
+	password string
+}
+
+// Netrc holds basic auth credentials keyed by host, as read from a netrc file.
+type Netrc struct {
+	machines map[string]netrcMachine
+	fallback *netrcMachine
+}
+
+// ReadNetrc reads the "machine", "default", "login" and "password" entries of a netrc file. Macro definitions and
+// accounts are ignored.
+func ReadNetrc(path string) (*Netrc, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening netrc file=%s: %s", path, err)
+	}
+	defer f.Close()
+	n, err := parseNetrc(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading netrc file=%s: %s", path, err)
+	}
+	return n, nil
+}
+
+func parseNetrc(r io.Reader) (*Netrc, error) {
+	n := &Netrc{machines: make(map[string]netrcMachine)}
+
+	var tokens []string
+	inMacro := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// a macro definition runs until the next empty line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i, f := range fields {
+			if f == "macdef" {
+				tokens = append(tokens, fields[:i]...)
+				inMacro = true
+				break
+			}
+		}
+		if !inMacro {
+			tokens = append(tokens, fields...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var host string
+	var current *netrcMachine
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if host == "" {
+			n.fallback = current
+		} else {
+			n.machines[host] = *current
+		}
+	}
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			if i+1 >= len(tokens) {
+				return nil, fmt.Errorf("missing host after machine")
+			}
+			flush()
+			i++
+			host = tokens[i]
+			current = &netrcMachine{}
+		case "default":
+			flush()
+			host = ""
+			current = &netrcMachine{}
+		case "login", "password", "account":
+			if current == nil || i+1 >= len(tokens) {
+				return nil, fmt.Errorf("unexpected %q", tokens[i])
+			}
+			switch tokens[i] {
+			case "login":
+				current.login = tokens[i+1]
+			case "password":
+				current.password = tokens[i+1]
+			}
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q", tokens[i])
+		}
+	}
+	flush()
+	return n, nil
+}
+
+// Authenticator returns basic auth for the host of baseURL, matching "host:port" before "host" and falling back to
+// the default entry. It returns nil when the file holds no credentials for the host.
+func (n *Netrc) Authenticator(baseURL string) Authenticator {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil
+	}
+	m, found := n.machines[u.Host]
+	if !found {
+		m, found = n.machines[u.Hostname()]
+	}
+	if !found {
+		if n.fallback == nil {
+			return nil
+		}
+		m = *n.fallback
+	}
+	return BasicAuth(m.login, m.password)
+}
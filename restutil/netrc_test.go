@@ -0,0 +1,55 @@
+package restutil
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const netrc = `# credentials for the test clusters
+machine pre-prod.example.com login preuser password prepass
+machine localhost:8080
+	login localuser
+	password localpass
+macdef init
+	cd /pub
+	bin
+
+default login anonymous password guest
+`
+
+func TestNetrc_AuthenticatorByHost(t *testing.T) {
+	n, err := parseNetrc(strings.NewReader(netrc))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		baseURL string
+		auth    Authenticator
+	}{
+		{"https://pre-prod.example.com/things/", BasicAuth("preuser", "prepass")},
+		{"https://pre-prod.example.com:8443/things/", BasicAuth("preuser", "prepass")},
+		{"http://localhost:8080/things/", BasicAuth("localuser", "localpass")},
+		{"http://localhost:9090/things/", BasicAuth("anonymous", "guest")},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.auth, n.Authenticator(test.baseURL), test.baseURL)
+	}
+}
+
+func TestNetrc_NoDefault(t *testing.T) {
+	n, err := parseNetrc(strings.NewReader("machine example.com login user password pass"))
+	assert.NoError(t, err)
+	assert.Nil(t, n.Authenticator("http://localhost/things/"))
+}
+
+func TestNetrc_Invalid(t *testing.T) {
+	_, err := parseNetrc(strings.NewReader("login user password pass"))
+	assert.Error(t, err)
+	_, err = parseNetrc(strings.NewReader("machine"))
+	assert.Error(t, err)
+}
+
+func TestReadNetrc_MissingFile(t *testing.T) {
+	_, err := ReadNetrc("non_existing_file")
+	assert.Error(t, err)
+}
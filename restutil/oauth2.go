@@ -0,0 +1,152 @@
+package restutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryMargin is how long before its expiry a token is refreshed, so it does not expire in flight. Tokens lasting
+// less than twice as long are refreshed halfway through their lifetime instead.
+const tokenExpiryMargin = 30 * time.Second
+
+// invalidator is implemented by authenticators whose credentials may be rejected before they expect, so that
+// authTransport can ask for fresh ones on a 401 response.
+type invalidator interface {
+	Invalidate()
+}
+
+type clientCredentials struct {
+	sync.Mutex
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	token        string
+	expiry       time.Time
+	// refreshing is closed once the token being fetched is known, and nil when none is
+	refreshing chan struct{}
+	// now tells the time the expiry is checked against, time.Now but in tests
+	now func() time.Time
+}
+
+// OAuth2ClientCredentials authenticates requests with a bearer token obtained from tokenURL using the OAuth2 client
+// credentials grant. The token is refreshed shortly before it expires, and whenever a request gets a 401 response.
+//
+// The token is fetched by the client making the request being authenticated, within its context, while the other
+// requests needing it wait until it is known or their context is done.
+func OAuth2ClientCredentials(tokenURL, clientID, clientSecret string, scopes []string) Authenticator {
+	return &clientCredentials{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		now:          time.Now,
+	}
+}
+
+func (c *clientCredentials) Authenticate(req *http.Request) error {
+	for {
+		c.Lock()
+		if c.token != "" && c.now().Before(c.expiry) {
+			token := c.token
+			c.Unlock()
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		}
+		refreshing := c.refreshing
+		if refreshing == nil {
+			// fetch the token without holding the lock, so that the requests waiting for it can give up
+			refreshing = make(chan struct{})
+			c.refreshing = refreshing
+			c.Unlock()
+			token, expiry, err := c.fetchToken(req)
+			c.Lock()
+			if err == nil {
+				c.token, c.expiry = token, expiry
+			}
+			c.refreshing = nil
+			c.Unlock()
+			close(refreshing)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		}
+		c.Unlock()
+		select {
+		case <-refreshing:
+		case <-req.Context().Done():
+			return req.Context().Err()
+		}
+	}
+}
+
+func (c *clientCredentials) Invalidate() {
+	c.Lock()
+	defer c.Unlock()
+	c.token = ""
+}
+
+// fetchToken fetches a token with the client making authReq, within its context, and returns it along with the time
+// it should be refreshed at.
+func (c *clientCredentials) fetchToken(authReq *http.Request) (string, time.Time, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.scopes) > 0 {
+		form.Set("scope", strings.Join(c.scopes, " "))
+	}
+	req, err := http.NewRequest("POST", c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+	// the token endpoint may share a base URL with the endpoints it authenticates, so it is called unauthenticated
+	req = req.WithContext(withoutAuth(authReq.Context()))
+
+	resp, _, err := clientOf(authReq).send(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error fetching OAuth2 token: %s", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("error fetching OAuth2 token: %s", resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", time.Time{}, fmt.Errorf("error decoding OAuth2 token: %s", err)
+	}
+	if token.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("error fetching OAuth2 token: no access_token in response")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", time.Time{}, fmt.Errorf("error fetching OAuth2 token: unsupported token_type %q", token.TokenType)
+	}
+
+	expiry := c.now().Add(time.Hour)
+	if token.ExpiresIn > 0 {
+		lifetime := time.Duration(token.ExpiresIn) * time.Second
+		margin := tokenExpiryMargin
+		if margin > lifetime/2 {
+			margin = lifetime / 2
+		}
+		expiry = c.now().Add(lifetime - margin)
+	}
+	return token.AccessToken, expiry, nil
+}
@@ -0,0 +1,209 @@
+package restutil
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type stubTokenEndpoint struct {
+	*httptest.Server
+	sync.Mutex
+	issued    int
+	expiresIn int
+	forms     []string
+	agents    []string
+}
+
+func newStubTokenEndpoint(expiresIn int) *stubTokenEndpoint {
+	e := &stubTokenEndpoint{expiresIn: expiresIn}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.Lock()
+		defer e.Unlock()
+		id, secret, _ := r.BasicAuth()
+		if r.Method != "POST" || id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		e.forms = append(e.forms, r.PostForm.Encode())
+		e.agents = append(e.agents, r.UserAgent())
+		e.issued++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`, e.issued, e.expiresIn)
+	}))
+	return e
+}
+
+func (e *stubTokenEndpoint) tokensIssued() int {
+	e.Lock()
+	defer e.Unlock()
+	return e.issued
+}
+
+func newTokenCheckingServer(accepted ...string) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var seen []string
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		auth := r.Header.Get("Authorization")
+		seen = append(seen, auth)
+		for _, a := range accepted {
+			if auth == "Bearer "+a {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	})), &seen
+}
+
+func TestOAuth2ClientCredentials_ReusesToken(t *testing.T) {
+	tokens := newStubTokenEndpoint(3600)
+	defer tokens.Close()
+	server, seen := newTokenCheckingServer("token-1")
+	defer server.Close()
+
+	SetAuthenticator(server.URL, OAuth2ClientCredentials(tokens.URL, "client", "secret", []string{"read", "write"}))
+	defer SetAuthenticator(server.URL, nil)
+
+	for i := 0; i < 3; i++ {
+		_, err := DefaultClient.doGet(context.Background(), server.URL, "UUID-1")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, tokens.tokensIssued())
+	assert.Equal(t, []string{"grant_type=client_credentials&scope=read+write"}, tokens.forms)
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-1", "Bearer token-1"}, *seen)
+}
+
+// withClock makes auth, an OAuth2ClientCredentials authenticator, check expiry against the returned time, which the
+// returned function advances.
+func withClock(auth Authenticator) func(time.Duration) {
+	now := time.Now()
+	auth.(*clientCredentials).now = func() time.Time { return now }
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestOAuth2ClientCredentials_RefreshesExpiredToken(t *testing.T) {
+	tokens := newStubTokenEndpoint(60)
+	defer tokens.Close()
+	server, seen := newTokenCheckingServer("token-1", "token-2")
+	defer server.Close()
+
+	auth := OAuth2ClientCredentials(tokens.URL, "client", "secret", nil)
+	advance := withClock(auth)
+	SetAuthenticator(server.URL, auth)
+	defer SetAuthenticator(server.URL, nil)
+
+	for _, d := range []time.Duration{0, 29 * time.Second, 2 * time.Second} {
+		advance(d)
+		_, err := DefaultClient.doGet(context.Background(), server.URL, "UUID-1")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, tokens.tokensIssued())
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-1", "Bearer token-2"}, *seen)
+}
+
+func TestOAuth2ClientCredentials_ReusesShortLivedToken(t *testing.T) {
+	tokens := newStubTokenEndpoint(10)
+	defer tokens.Close()
+	server, seen := newTokenCheckingServer("token-1", "token-2")
+	defer server.Close()
+
+	auth := OAuth2ClientCredentials(tokens.URL, "client", "secret", nil)
+	advance := withClock(auth)
+	SetAuthenticator(server.URL, auth)
+	defer SetAuthenticator(server.URL, nil)
+
+	for _, d := range []time.Duration{0, 4 * time.Second, 2 * time.Second} {
+		advance(d)
+		_, err := DefaultClient.doGet(context.Background(), server.URL, "UUID-1")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, tokens.tokensIssued())
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-1", "Bearer token-2"}, *seen)
+}
+
+func TestOAuth2ClientCredentials_RefreshesOnUnauthorized(t *testing.T) {
+	tokens := newStubTokenEndpoint(3600)
+	defer tokens.Close()
+	server, seen := newTokenCheckingServer("token-2")
+	defer server.Close()
+
+	SetAuthenticator(server.URL, OAuth2ClientCredentials(tokens.URL, "client", "secret", nil))
+	defer SetAuthenticator(server.URL, nil)
+
+	rp := &resourcePutter{client: DefaultClient, baseURL: server.URL}
+	err := rp.put(server.URL+"/UUID-1", strings.NewReader("{}"), "application/json")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, tokens.tokensIssued())
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, *seen)
+}
+
+func TestOAuth2ClientCredentials_TokenFailure(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{}
+
+	tokens := newStubTokenEndpoint(3600)
+	defer tokens.Close()
+	server, _ := newTokenCheckingServer("token-1")
+	defer server.Close()
+
+	SetAuthenticator(server.URL, OAuth2ClientCredentials(tokens.URL, "client", "wrong", nil))
+	defer SetAuthenticator(server.URL, nil)
+
+	_, err := DefaultClient.doGet(context.Background(), server.URL, "UUID-1")
+	assert.Error(t, err)
+}
+
+func TestOAuth2ClientCredentials_FetchesTokenWithItsClient(t *testing.T) {
+	tokens := newStubTokenEndpoint(3600)
+	defer tokens.Close()
+	server, seen := newTokenCheckingServer("token-1")
+	defer server.Close()
+
+	c := NewClient(WithUserAgent("job"), WithAuthenticator(server.URL, OAuth2ClientCredentials(tokens.URL, "client", "secret", nil)))
+	_, err := c.doGet(context.Background(), server.URL, "UUID-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"job"}, tokens.agents)
+	assert.Equal(t, []string{"Bearer token-1"}, *seen)
+}
+
+func TestOAuth2ClientCredentials_CancelStopsWaitingForToken(t *testing.T) {
+	release := make(chan struct{})
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer tokens.Close()
+	defer close(release)
+	server, seen := newTokenCheckingServer("token-1")
+	defer server.Close()
+
+	c := NewClient(WithRetryPolicy(&RetryPolicy{}), WithAuthenticator(server.URL, OAuth2ClientCredentials(tokens.URL, "client", "secret", nil)))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.doGet(ctx, server.URL, "UUID-1")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Error(t, err)
+	}
+	assert.Empty(t, *seen)
+}
@@ -1,17 +1,16 @@
 package restutil
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	log "github.com/Sirupsen/logrus"
 	"golang.org/x/net/context"
-	"golang.org/x/time/rate"
-	"gopkg.in/cheggaaa/pb.v1"
 	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -20,60 +19,54 @@ import (
 	"time"
 )
 
-var (
-	Transport = &http.Transport{
-		MaxIdleConnsPerHost: 128,
-		Dial: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).Dial,
-	}
-
-	HttpClient = &http.Client{
-		Transport: Transport,
-	}
-)
-
 const (
 	BufferSize = 24
 )
 
+// DrainTimeout is how long the requests in flight are given to complete once the context of a run is cancelled, by the
+// clients not given a timeout with WithDrainTimeout.
+var DrainTimeout = 20 * time.Second
+
 type binaryMsg struct {
 	id   *string
 	body *io.ReadCloser
 	ct   string
 }
 
-func PutAllBinaryRest(baseFromURL string, baseToURL string, user string, pass string, conns int, throttle int, dumpFailed bool) (err error) {
+// PutAllBinaryRest copies the resources of the collection at baseFromURL to baseToURL with DefaultClient.
+func PutAllBinaryRest(ctx context.Context, baseFromURL string, baseToURL string, user string, pass string, conns int, limiter *RateLimiter, dumpFailed io.Writer, failures *FailureReport) (*Summary, error) {
+	return DefaultClient.PutAllBinaryRest(ctx, baseFromURL, baseToURL, user, pass, conns, limiter, dumpFailed, failures)
+}
+
+// PutAllBinaryRest copies the resources of the collection at baseFromURL to baseToURL as they are, along with their
+// Content-Type. The ids of the resources that fail are written to dumpFailed when given, one per line.
+func (c *Client) PutAllBinaryRest(ctx context.Context, baseFromURL string, baseToURL string, user string, pass string, conns int, limiter *RateLimiter, dumpFailed io.Writer, failures *FailureReport) (*Summary, error) {
+	summary := c.newSummary()
+	reqCtx, cancel := c.drainContext(ctx)
+	defer cancel()
 	msgs := make(chan *binaryMsg, 128)
 	var failChan chan []byte
 	rp := &resourcePutter{
-		baseURL: baseToURL,
-		user:    user,
-		pass:    pass,
+		client:      c,
+		ctx:         reqCtx,
+		baseURL:     baseToURL,
+		user:        user,
+		pass:        pass,
+		limiter:     limiter,
+		observeOnly: true,
+		failures:    failures,
+		summary:     summary,
+		progress:    c.newProgress(opCopy, 0),
 	}
 
-	errs := make(chan error)
+	errs := make(chan error, 1)
 	failwg := sync.WaitGroup{}
-	if dumpFailed {
+	if dumpFailed != nil {
 		failChan = make(chan []byte, conns*2)
 		failwg.Add(1)
 		go func() {
 			defer failwg.Done()
-
-			for resource := range failChan {
-				_, err := os.Stdout.Write(resource)
-				if err == nil {
-					_, err = io.WriteString(os.Stdout, "\n")
-				}
-				if err != nil {
-					select {
-					case errs <- err:
-					default:
-					}
-					return
-				}
-			}
+			dumpResources(dumpFailed, failChan, errs)
 		}()
 
 	}
@@ -84,71 +77,91 @@ func PutAllBinaryRest(baseFromURL string, baseToURL string, user string, pass st
 		wg.Add(1)
 		go rp.putAllBinary(msgs, failChan, &wg)
 	}
-	getAllBinary(baseFromURL, throttle, conns, msgs)
+	listErr := c.getAllBinary(ctx, reqCtx, baseFromURL, limiter, failures, summary, rp.progress, conns, msgs)
 	wg.Wait()
+	rp.progress.finish("Done copies")
 
-	if dumpFailed {
+	if dumpFailed != nil {
 		close(failChan)
 		failwg.Wait()
 	}
 
 	select {
 	case err := <-errs:
-		return err
+		return summary.finish(), err
 	default:
-		return nil
 	}
+	if listErr != nil {
+		return summary.finish(), listErr
+	}
+	return summary.finish(), ctx.Err()
 }
 
-func getAllBinary(baseURL string, throttle int, conns int, msgs chan *binaryMsg) (err error) {
+// getAllBinary stops listing ids when ctx is done, and fetches the ones listed with reqCtx. It returns an *IDListError
+// if the ids cannot be listed.
+func (c *Client) getAllBinary(ctx context.Context, reqCtx context.Context, baseURL string, limiter *RateLimiter, failures *FailureReport, summary *Summary, prog *progress, conns int, msgs chan *binaryMsg) error {
 	ids := make(chan *string, conns*BufferSize)
-	go fetchIDList(baseURL, ids)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	listErr := make(chan error, 1)
+	go func() {
+		listErr <- c.fetchIDList(ctx, baseURL, ids, prog)
+	}()
 
 	var wg sync.WaitGroup
-	var rl *rate.Limiter
-	if throttle > 0 {
-		rl = rate.NewLimiter(rate.Limit(throttle), 1)
-	}
 
 	for i := 0; i < conns; i++ {
 		wg.Add(1)
-		go fetchBinaryMessage(baseURL, ids, msgs, rl, &ctx, &wg)
+		go c.fetchBinaryMessage(ctx, reqCtx, baseURL, ids, msgs, limiter, failures, summary, prog, &wg)
 	}
 	wg.Wait()
 	close(msgs)
-	return err
+	if err := <-listErr; err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
 }
 
-func fetchBinaryMessage(baseURL string, ids chan *string, msgs chan<- *binaryMsg, lim *rate.Limiter, ctx *context.Context, wg *sync.WaitGroup) {
+// fetchBinaryMessage fetches the resources listed in ids with reqCtx, skipping the rest once ctx is done.
+func (c *Client) fetchBinaryMessage(ctx context.Context, reqCtx context.Context, baseURL string, ids chan *string, msgs chan<- *binaryMsg, lim *RateLimiter, failures *FailureReport, summary *Summary, prog *progress, wg *sync.WaitGroup) {
 	for id := range ids {
-		log.Infof("Fetching ID=%v", *id)
-		var err error
-		if lim != nil {
-			if err := lim.Wait(*ctx); err != nil {
-				log.Errorf("Got error rate limiting, %v", err.Error())
-				ids <- id
-				continue
-			}
-
+		if ctx.Err() != nil {
+			continue
+		}
+		c.log.Infof("Fetching ID=%v", *id)
+		reqURI, err := generatePutURL(*id, baseURL)
+		if err != nil {
+			c.log.Errorf("Got invalid ID=%v, %v", *id, err.Error())
+			c.reportFailure(failures, opCopy, *id, &ResourceError{Op: opCopy, ID: *id, Err: err})
+			summary.addFailed(opCopy)
+			prog.increment()
+			continue
 		}
-
-		reqURI, _ := generatePutURL(*id, baseURL)
 		req, err := http.NewRequest("GET", reqURI.String(), nil)
 		if err != nil {
-			log.Errorf("Got error creating NewRequest, %v", err.Error())
+			c.log.Errorf("Got error creating NewRequest, %v", err.Error())
+			summary.addFailed(opCopy)
+			prog.increment()
 			continue
 		}
+		req = req.WithContext(withRateLimiter(reqCtx, lim))
 
-		req.Header.Set("User-Agent", Useragent)
-		resp, err := HttpClient.Do(req)
+		resp, attempts, err := c.send(req)
 		if err != nil {
-			log.Errorf("Got error making request, %v", err.Error())
+			c.log.Errorf("Got error making request, %v", err.Error())
+			c.reportFailure(failures, opCopy, *id, newRequestError("error fetching resource", req, nil, attempts, err))
+			summary.addFailed(opCopy)
+			prog.increment()
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			c.log.Errorf("Got unexpected status fetching ID=%v, %v", *id, resp.Status)
+			c.reportFailure(failures, opCopy, *id, newRequestError("error fetching resource", req, resp, attempts, nil))
+			io.Copy(ioutil.Discard, resp.Body)
 			resp.Body.Close()
+			summary.addFailed(opCopy)
+			prog.increment()
 			continue
 		}
+		summary.addRead(1)
 
 		msg := &binaryMsg{
 			id:   id,
@@ -162,15 +175,31 @@ func fetchBinaryMessage(baseURL string, ids chan *string, msgs chan<- *binaryMsg
 	wg.Done()
 }
 
-func PutAllRest(baseURL string, idProperty string, user string, pass string, conns int, dumpFailed bool) error {
+// PutAllRest PUTs the JSON resources read from in to the collection at baseURL with DefaultClient.
+func PutAllRest(ctx context.Context, baseURL string, idProperty string, user string, pass string, conns int, limiter *RateLimiter, dumpFailed io.Writer, failures *FailureReport, in io.Reader) (*Summary, error) {
+	return DefaultClient.PutAllRest(ctx, baseURL, idProperty, user, pass, conns, limiter, dumpFailed, failures, in)
+}
 
-	dec := json.NewDecoder(os.Stdin)
+// PutAllRest PUTs the JSON resources read from in, which may be compressed with gzip or zstd, to the collection at
+// baseURL. The resources that fail are written to dumpFailed when given, one per line, rather than stopping the run.
+//
+// It stops reading resources when ctx is done, and waits for the PUTs in flight before returning.
+func (c *Client) PutAllRest(ctx context.Context, baseURL string, idProperty string, user string, pass string, conns int, limiter *RateLimiter, dumpFailed io.Writer, failures *FailureReport, in io.Reader) (*Summary, error) {
+	summary := c.newSummary()
+	reqCtx, cancel := c.drainContext(ctx)
+	defer cancel()
 
-	docs := make(chan resource)
+	r, err := Decompress(in)
+	if err != nil {
+		return summary.finish(), err
+	}
+	defer r.Close()
+	dec := json.NewDecoder(r)
 
-	Transport.MaxIdleConnsPerHost = conns
+	docs := make(chan resource)
 
-	rp := &resourcePutter{baseURL, idProperty, user, pass}
+	rp := &resourcePutter{client: c, ctx: reqCtx, baseURL: baseURL, idProperty: idProperty, user: user, pass: pass, limiter: limiter, failures: failures, summary: summary, progress: c.newProgress(opPut, 0)}
+	defer rp.progress.finish("Done puts")
 
 	errs := make(chan error, 1)
 
@@ -178,26 +207,13 @@ func PutAllRest(baseURL string, idProperty string, user string, pass string, con
 
 	failwg := sync.WaitGroup{}
 
-	if dumpFailed {
+	if dumpFailed != nil {
 		failChan = make(chan []byte)
 
 		failwg.Add(1)
 		go func() {
 			defer failwg.Done()
-
-			for resource := range failChan {
-				_, err := os.Stdout.Write(resource)
-				if err == nil {
-					_, err = io.WriteString(os.Stdout, "\n")
-				}
-				if err != nil {
-					select {
-					case errs <- err:
-					default:
-					}
-					return
-				}
-			}
+			dumpResources(dumpFailed, failChan, errs)
 		}()
 	}
 
@@ -216,18 +232,24 @@ func PutAllRest(baseURL string, idProperty string, user string, pass string, con
 		}()
 	}
 
-	for {
+	// stop reading at the first error, but let the workers and the dump of failed resources finish before returning it
+	var readErr error
+read:
+	for ctx.Err() == nil {
 		var doc map[string]interface{}
 		if err := dec.Decode(&doc); err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				readErr = err
 			}
-			return err
+			break
 		}
+		summary.addRead(1)
 		select {
 		case docs <- doc:
-		case err := <-errs:
-			return err
+		case readErr = <-errs:
+			break read
+		case <-ctx.Done():
+			summary.addSkipped(1)
 		}
 	}
 
@@ -235,46 +257,57 @@ func PutAllRest(baseURL string, idProperty string, user string, pass string, con
 
 	wg.Wait()
 
-	if dumpFailed {
+	if dumpFailed != nil {
 		close(failChan)
 		failwg.Wait()
 	}
 
+	if readErr != nil {
+		return summary.finish(), readErr
+	}
 	select {
 	case err := <-errs:
-		return err
+		return summary.finish(), err
 	default:
-		return nil
+		return summary.finish(), ctx.Err()
 	}
 
 }
 
-func DiffIDs(sourceURL, destURL string) error {
-	sourceIDs := make(chan *string)
-	go fetchIDList(sourceURL, sourceIDs)
-
-	destIDs := make(chan *string)
-	go fetchIDList(destURL, destIDs)
-
-	sources := make(map[string]struct{})
-	dests := make(map[string]struct{})
-
-	for sourceIDs != nil || destIDs != nil {
-		select {
-		case sourceID, ok := <-sourceIDs:
-			if !ok {
-				sourceIDs = nil
-			} else {
-				sources[*sourceID] = struct{}{}
-			}
-		case destID, ok := <-destIDs:
-			if !ok {
-				destIDs = nil
-			} else {
-				dests[*destID] = struct{}{}
+// dumpResources writes every resource received from failed to w, one per line. After the first error writing, it sends
+// the error to errs unless one is already pending there, and drains the remaining resources so that senders do not
+// block.
+func dumpResources(w io.Writer, failed <-chan []byte, errs chan<- error) {
+	var err error
+	for resource := range failed {
+		if err != nil {
+			continue
+		}
+		if _, err = w.Write(resource); err == nil {
+			_, err = io.WriteString(w, "\n")
+		}
+		if err != nil {
+			select {
+			case errs <- err:
+			default:
 			}
 		}
 	}
+}
+
+// DiffIDs writes the ids found in only one of the collections at sourceURL and destURL to out with DefaultClient.
+func DiffIDs(ctx context.Context, sourceURL, destURL string, out io.Writer) (*Summary, error) {
+	return DefaultClient.DiffIDs(ctx, sourceURL, destURL, out)
+}
+
+// DiffIDs writes the ids found in only one of the collections at sourceURL and destURL to out, as JSON.
+func (c *Client) DiffIDs(ctx context.Context, sourceURL, destURL string, out io.Writer) (*Summary, error) {
+	summary := c.newSummary()
+	sources, dests, err := c.fetchIDSets(ctx, sourceURL, destURL)
+	if err != nil {
+		return summary.finish(), err
+	}
+	summary.addRead(int64(len(sources) + len(dests)))
 
 	var output struct {
 		OnlyInSource      []string `json:"only-in-source"`
@@ -297,8 +330,56 @@ func DiffIDs(sourceURL, destURL string) error {
 		output.OnlyInDestination = append(output.OnlyInDestination, s)
 	}
 
-	return json.NewEncoder(os.Stdout).Encode(output)
+	err = json.NewEncoder(out).Encode(output)
+	return summary.finish(), err
+
+}
+
+// fetchIDSets lists the ids of both collections, returning an *IDListError if either cannot be listed, or the error of
+// ctx once done.
+func (c *Client) fetchIDSets(ctx context.Context, sourceURL, destURL string) (map[string]struct{}, map[string]struct{}, error) {
+	listCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	errs := make(chan error, 2)
+
+	sourceIDs := make(chan *string)
+	go func() {
+		errs <- c.fetchIDList(listCtx, sourceURL, sourceIDs, nil)
+	}()
+
+	destIDs := make(chan *string)
+	go func() {
+		errs <- c.fetchIDList(listCtx, destURL, destIDs, nil)
+	}()
+
+	sources := make(map[string]struct{})
+	dests := make(map[string]struct{})
 
+	for sourceIDs != nil || destIDs != nil {
+		select {
+		case sourceID, ok := <-sourceIDs:
+			if !ok {
+				sourceIDs = nil
+			} else {
+				sources[*sourceID] = struct{}{}
+			}
+		case destID, ok := <-destIDs:
+			if !ok {
+				destIDs = nil
+			} else {
+				dests[*destID] = struct{}{}
+			}
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			return nil, nil, err
+		}
+	}
+	return sources, dests, nil
 }
 
 type SyncService struct {
@@ -308,17 +389,181 @@ type SyncService struct {
 	DestURL            string
 	MaxConcurrentReqs  int
 	MinExecTime        int
-	Retries            int
 	Deletes            bool
+	CompareContent     bool
+	Checkpoint         string
+	Resume             bool
+	DryRun             bool
+	MaxDeletes         int
+	MaxDeleteRatio     float64
+	// SourceLimiter limits the rate of requests to the source, and DestLimiter that of requests to the destination.
+	// Either may be nil for no limit.
+	SourceLimiter *RateLimiter
+	DestLimiter   *RateLimiter
+	// Failures, when set, records failed operations and lets the sync carry on past them.
+	Failures *FailureReport
+	// PlanOutput receives the plan of a dry run as JSON, and must be set for one.
+	PlanOutput io.Writer
+	// Retries, when above zero, is the number of times the failed requests of the service are retried, instead of
+	// the MaxRetries of the retry policy of the client.
+	//
+	// Deprecated: give the client a retry policy with WithRetryPolicy instead.
+	Retries int
+
+	client  *Client
+	summary *Summary
+	// ctx stops the run when done, and reqCtx outlives it by the drain timeout of the client for the requests in flight
+	ctx    context.Context
+	reqCtx context.Context
+}
+
+// SyncPlan lists the operations a sync would perform on the destination collection.
+type SyncPlan struct {
+	SourceURL string   `json:"source"`
+	DestURL   string   `json:"destination"`
+	Create    []string `json:"create"`
+	Update    []string `json:"update"`
+	Delete    []string `json:"delete"`
+	DestSize  int      `json:"destination-size"`
+}
+
+func ReadSyncPlan(path string) (*SyncPlan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening plan file=%s: %s", path, err)
+	}
+	defer f.Close()
+	var plan SyncPlan
+	if err := json.NewDecoder(f).Decode(&plan); err != nil {
+		return nil, fmt.Errorf("error reading plan file=%s: %s", path, err)
+	}
+	return &plan, nil
+}
+
+// SyncIDs makes the destination collection of service match its source with DefaultClient.
+func SyncIDs(ctx context.Context, service *SyncService) (*Summary, error) {
+	return DefaultClient.SyncIDs(ctx, service)
+}
+
+// SyncIDs stops starting operations when ctx is done, and waits for the ones in flight before returning. It returns an
+// *IDListError if the ids of either collection cannot be listed from its __ids resource.
+func (c *Client) SyncIDs(ctx context.Context, service *SyncService) (*Summary, error) {
+	defer service.start(ctx, c)()
+	err := service.syncIDs()
+	return service.summary.finish(), err
+}
+
+// start prepares the service for a run with c stopping when ctx is done, and returns the function to call once it is
+// over.
+func (service *SyncService) start(ctx context.Context, c *Client) context.CancelFunc {
+	service.client = c
+	service.summary = c.newSummary()
+	service.ctx = ctx
+	reqCtx, cancel := c.drainContext(ctx)
+	if service.Retries > 0 {
+		policy := *c.retryPolicy()
+		policy.MaxRetries = service.Retries
+		reqCtx = withRetryPolicy(reqCtx, &policy)
+	}
+	service.reqCtx = reqCtx
+	return cancel
+}
+
+func (service *SyncService) syncIDs() error {
+	if service.DryRun && service.PlanOutput == nil {
+		return errors.New("a plan output must be provided for a dry run")
+	}
+	// a dry run issues no PUT or DELETE, so it leaves the checkpoint of the sync it plans alone
+	var journal *checkpoint
+	var err error
+	if !service.DryRun {
+		if journal, err = service.openCheckpoint(); err != nil {
+			return err
+		}
+		defer journal.Close()
+	}
+
+	// a resumed sync carries on with the plan of the interrupted one, rather than retrieving the ids again
+	var plan *SyncPlan
+	recorded := journal.loadedPlan()
+	resumed := recorded != nil
+	if resumed {
+		if err := service.checkResumable(recorded); err != nil {
+			return err
+		}
+		service.client.log.Infof("Resuming the sync planned in checkpoint file=%s", service.Checkpoint)
+		plan = recorded.Plan
+	} else if plan, err = service.planSync(); err != nil {
+		return err
+	}
+
+	if service.DryRun {
+		if err := service.checkDeleteLimits(len(plan.Delete), plan.DestSize); err != nil {
+			service.client.log.Warn(err)
+		}
+		if plan.Update, err = service.runAll("Done comparisons", opCompare, plan.Update, nil, func(id string) (bool, error) {
+			return service.contentDiffers(id)
+		}); err != nil {
+			return err
+		}
+		return json.NewEncoder(service.PlanOutput).Encode(plan)
+	}
+
+	if err := service.checkDeleteLimits(len(plan.Delete), plan.DestSize); err != nil {
+		return err
+	}
+	if !resumed {
+		if err := journal.recordPlan(plannedSync{Plan: plan, Deletes: service.Deletes, CompareContent: service.CompareContent}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := service.runAll("Done creates", opCreate, plan.Create, journal, func(id string) (bool, error) {
+		return true, service.copy(id)
+	}); err != nil {
+		return err
+	}
+
+	// drifted resources are counted as updates, as apply-plan counts them
+	if _, err := service.runAll("Done updates", opUpdate, plan.Update, journal, func(id string) (bool, error) {
+		return service.syncContent(id)
+	}); err != nil {
+		return err
+	}
+
+	_, err = service.runAll("Done deletes", opDelete, plan.Delete, journal, func(id string) (bool, error) {
+		return true, service.delete(id)
+	})
+	return err
+}
+
+// checkResumable refuses to resume a sync planned between other collections, or with other options, than those of the
+// service.
+func (service *SyncService) checkResumable(recorded *plannedSync) error {
+	if recorded.Plan == nil {
+		return fmt.Errorf("checkpoint file=%s records no plan to resume", service.Checkpoint)
+	}
+	if recorded.Plan.SourceURL != service.SourceURL || recorded.Plan.DestURL != service.DestURL {
+		return fmt.Errorf("cannot resume the sync from %s to %s recorded in checkpoint file=%s as a sync from %s to %s", recorded.Plan.SourceURL, recorded.Plan.DestURL, service.Checkpoint, service.SourceURL, service.DestURL)
+	}
+	if recorded.Deletes != service.Deletes || recorded.CompareContent != service.CompareContent {
+		return fmt.Errorf("cannot resume the sync with deletes=%t and compare=%t recorded in checkpoint file=%s with deletes=%t and compare=%t", recorded.Deletes, recorded.CompareContent, service.Checkpoint, service.Deletes, service.CompareContent)
+	}
+	return nil
 }
 
-func SyncIDs(service *SyncService) error {
-	errChan := make(chan error)
-	defer close(errChan)
+// planSync retrieves the ids of both collections and plans the sync. Its Update lists the resources present in both
+// whose content is to be compared, when CompareContent is set, rather than those known to differ.
+func (service *SyncService) planSync() (*SyncPlan, error) {
+	// stop the retrievers when returning early, so that they do not block on sending
+	listCtx, stop := context.WithCancel(service.ctx)
+	defer stop()
+	sourceErrs := make(chan error)
 	sourceIDs := make(chan string)
-	go service.SourceIDsRetriever.Retrieve(sourceIDs, errChan)
+	go service.SourceIDsRetriever.Retrieve(listCtx, sourceIDs, sourceErrs)
+	destErrs := make(chan error)
 	destIDs := make(chan string)
-	go service.DestIDsRetriever.Retrieve(destIDs, errChan)
+	go service.DestIDsRetriever.Retrieve(listCtx, destIDs, destErrs)
 
 	sources := make(map[string]struct{})
 	dests := make(map[string]struct{})
@@ -337,14 +582,132 @@ func SyncIDs(service *SyncService) error {
 			} else {
 				dests[destID] = struct{}{}
 			}
-		case err := <-errChan:
-			return err
+		case err := <-sourceErrs:
+			return nil, retrieverError(service.SourceIDsRetriever, service.SourceURL, err)
+		case err := <-destErrs:
+			return nil, retrieverError(service.DestIDsRetriever, service.DestURL, err)
+		case <-service.ctx.Done():
+			return nil, service.ctx.Err()
 		}
 	}
 
-	var output struct {
-		Created int `json:"created"`
-		Deleted int `json:"created"`
+	plan := &SyncPlan{
+		SourceURL: service.SourceURL,
+		DestURL:   service.DestURL,
+		Create:    []string{},
+		Update:    []string{},
+		Delete:    []string{},
+		DestSize:  len(dests),
+	}
+	for s := range sources {
+		if _, found := dests[s]; !found {
+			plan.Create = append(plan.Create, s)
+		} else {
+			delete(dests, s)
+			if service.CompareContent {
+				plan.Update = append(plan.Update, s)
+			} else {
+				service.summary.addSkipped(1)
+			}
+		}
+	}
+	if service.Deletes {
+		for s := range dests {
+			plan.Delete = append(plan.Delete, s)
+		}
+	}
+	return plan, nil
+}
+
+// idListError returns err as an *IDListError of the collection at baseURL, unless it already is one.
+func idListError(baseURL string, err error) error {
+	if _, ok := err.(*IDListError); ok {
+		return err
+	}
+	return &IDListError{URL: baseURL, Err: err}
+}
+
+// retrieverError returns err as an *IDListError of the collection at baseURL if r lists its ids from there, and
+// unchanged otherwise, e.g. when r reads them from a file.
+func retrieverError(r IDListRetriever, baseURL string, err error) error {
+	if _, ok := r.(*urlBasedIDListRetriever); ok {
+		return idListError(baseURL, err)
+	}
+	return err
+}
+
+// ApplyPlan performs the operations of a previously computed plan with DefaultClient.
+func ApplyPlan(ctx context.Context, service *SyncService, plan *SyncPlan) (*Summary, error) {
+	return DefaultClient.ApplyPlan(ctx, service, plan)
+}
+
+// ApplyPlan performs the operations of a previously computed plan, using the source and destination URLs of the
+// service rather than those recorded in the plan.
+func (c *Client) ApplyPlan(ctx context.Context, service *SyncService, plan *SyncPlan) (*Summary, error) {
+	defer service.start(ctx, c)()
+	err := service.applyPlan(plan)
+	return service.summary.finish(), err
+}
+
+func (service *SyncService) applyPlan(plan *SyncPlan) error {
+	if err := service.checkDeleteLimits(len(plan.Delete), plan.DestSize); err != nil {
+		return err
+	}
+
+	journal, err := service.openCheckpoint()
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	if _, err := service.runAll("Done creates", opCreate, plan.Create, journal, func(id string) (bool, error) {
+		return true, service.copy(id)
+	}); err != nil {
+		return err
+	}
+
+	if _, err := service.runAll("Done updates", opUpdate, plan.Update, journal, func(id string) (bool, error) {
+		return true, service.copy(id)
+	}); err != nil {
+		return err
+	}
+
+	_, err = service.runAll("Done deletes", opDelete, plan.Delete, journal, func(id string) (bool, error) {
+		return true, service.delete(id)
+	})
+	return err
+}
+
+// checkDeleteLimits guards against wiping the destination, e.g. when the source __ids response is truncated or empty.
+// A MaxDeletes or MaxDeleteRatio of zero means no limit.
+func (service *SyncService) checkDeleteLimits(deletes int, destSize int) error {
+	if service.MaxDeletes > 0 && deletes > service.MaxDeletes {
+		return fmt.Errorf("refusing to delete %d of %d resources in destination, the limit is %d", deletes, destSize, service.MaxDeletes)
+	}
+	if service.MaxDeleteRatio > 0 && destSize > 0 && float64(deletes)/float64(destSize) > service.MaxDeleteRatio {
+		return fmt.Errorf("refusing to delete %d of %d resources in destination (%.2f%%), the limit is %.2f%%", deletes, destSize, 100*float64(deletes)/float64(destSize), 100*service.MaxDeleteRatio)
+	}
+	return nil
+}
+
+func (service *SyncService) openCheckpoint() (*checkpoint, error) {
+	if service.Checkpoint != "" {
+		return openCheckpoint(service.Checkpoint, service.Resume, service.client.log)
+	}
+	if service.Resume {
+		return nil, errors.New("a checkpoint file must be provided to resume")
+	}
+	return nil, nil
+}
+
+// runAll calls do for each id using up to MaxConcurrentReqs concurrent requests, and returns the ids for which do
+// reported a change. Ids already recorded for op in the journal are skipped, and successful ones are recorded. The
+// first failure stops the run with a *ResourceError, unless the service has a failure report to record it in. Changes are counted as
+// operations of the summary, and ids needing no change as skipped.
+func (service *SyncService) runAll(done string, op string, ids []string, journal *checkpoint, do func(id string) (bool, error)) ([]string, error) {
+	changed := []string{}
+	if len(ids) == 0 {
+		return changed, nil
 	}
 
 	sem := make(chan struct{}, service.MaxConcurrentReqs)
@@ -353,73 +716,86 @@ func SyncIDs(service *SyncService) error {
 	}
 
 	errs := make(chan error, 1)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	prog := service.client.newProgress(op, len(ids))
+	defer prog.finish(done)
 
-	if len(sources) > 0 {
-		var wg sync.WaitGroup
-		bar := pb.StartNew(len(sources))
-
-		for s := range sources {
-			if _, found := dests[s]; !found {
-				select {
-				case err := <-errs:
-					return err
-				default:
-					<-sem
-					wg.Add(1)
-					go func(id string) {
-						defer func() {
-							sem <- struct{}{}
-							wg.Done()
-						}()
-						minExecTime := time.After(time.Second * time.Duration(service.MinExecTime))
-						retry := service.Retries
-						for {
-							if err := doCopy(service.SourceURL, service.DestURL, id); err != nil {
-								if retry == 0 {
-									errs <- err
-									break
-								} else {
-									retry--
-									time.Sleep(time.Second * 2)
-								}
-							} else {
-								break
-							}
-						}
-						<-minExecTime
-					}(s)
-					output.Created++
-					bar.Increment()
+	for _, s := range ids {
+		if journal.completed(op, s) {
+			service.summary.addSkipped(1)
+			prog.increment()
+			continue
+		}
+		<-sem
+		select {
+		case err := <-errs:
+			wg.Wait()
+			return changed, err
+		case <-service.ctx.Done():
+			wg.Wait()
+			return changed, service.ctx.Err()
+		default:
+			wg.Add(1)
+			go func(id string) {
+				defer func() {
+					sem <- struct{}{}
+					wg.Done()
+				}()
+				minExecTime := time.After(time.Second * time.Duration(service.MinExecTime))
+				c, err := do(id)
+				if err != nil {
+					service.summary.addFailed(op)
+					if service.Failures != nil {
+						service.client.log.Errorf("Failed to %s ID=%v, Error=%v", op, id, err)
+						err = service.Failures.Report(op, id, err)
+					} else {
+						err = &ResourceError{Op: op, ID: id, Err: err}
+					}
+				} else if err = journal.record(op, id); err == nil {
+					if c {
+						service.summary.addOp(op)
+						mu.Lock()
+						changed = append(changed, id)
+						mu.Unlock()
+					} else {
+						service.summary.addSkipped(1)
+					}
 				}
-			} else {
-				bar.Increment()
-				delete(dests, s)
-			}
-
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+				}
+				<-minExecTime
+			}(s)
+			prog.increment()
 		}
-		wg.Wait()
-		bar.FinishPrint("Done creates")
 	}
+	wg.Wait()
 
-	if service.Deletes && len(dests) > 0 {
-		bar := pb.StartNew(len(dests))
-
-		for s := range dests {
-			if err := doDelete(service.DestURL, s); err != nil {
-				return err
-			}
-			output.Deleted++
-			bar.Increment()
-		}
-		bar.FinishPrint("Done deletes")
+	select {
+	case err := <-errs:
+		return changed, err
+	default:
+		return changed, nil
 	}
+}
+
+// sourceContext returns the context of requests to the source, carrying its rate limiter.
+func (service *SyncService) sourceContext() context.Context {
+	return withRateLimiter(service.reqCtx, service.SourceLimiter)
+}
 
-	return json.NewEncoder(os.Stdout).Encode(output)
+// destContext returns the context of requests to the destination, carrying its rate limiter.
+func (service *SyncService) destContext() context.Context {
+	return withRateLimiter(service.reqCtx, service.DestLimiter)
 }
 
-func doCopy(sourceURL, destURL, id string) error {
+func (service *SyncService) copy(id string) error {
 
-	su := sourceURL
+	su := service.SourceURL
 	if !strings.HasSuffix(su, "/") {
 		su = su + "/"
 	}
@@ -428,10 +804,10 @@ func doCopy(sourceURL, destURL, id string) error {
 	if err != nil {
 		return err
 	}
-	sreq.Header.Set("User-Agent", Useragent)
-	sresp, err := HttpClient.Do(sreq)
+	sreq = sreq.WithContext(service.sourceContext())
+	sresp, attempts, err := service.client.send(sreq)
 	if err != nil {
-		return err
+		return newRequestError("error copying resource", sreq, nil, attempts, err)
 	}
 	defer func() {
 		io.Copy(ioutil.Discard, sresp.Body)
@@ -439,38 +815,141 @@ func doCopy(sourceURL, destURL, id string) error {
 	}()
 
 	if sresp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error copying resource: %s", sresp.Status)
+		return newRequestError("error copying resource", sreq, sresp, attempts, nil)
+	}
+	// read the whole resource so that the PUT can be retried
+	body, err := ioutil.ReadAll(sresp.Body)
+	if err != nil {
+		return err
 	}
+	service.summary.addRead(1)
 
-	du := destURL
+	du := service.DestURL
 	if !strings.HasSuffix(du, "/") {
 		du = du + "/"
 	}
 
-	dreq, err := http.NewRequest("PUT", fmt.Sprintf("%s%s", du, id), sresp.Body)
+	dreq, err := http.NewRequest("PUT", fmt.Sprintf("%s%s", du, id), bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
-	dreq.Header.Set("User-Agent", Useragent)
-	dreq.Header.Set("Content-type", "application/json")
-	dresp, err := HttpClient.Do(dreq)
+	dreq = dreq.WithContext(service.destContext())
+	// keep the content type of the source, so that binary resources are copied as they are
+	ct := sresp.Header.Get("Content-Type")
+	if ct == "" {
+		ct = "application/json"
+	}
+	dreq.Header.Set("Content-type", ct)
+	dresp, attempts, err := service.client.send(dreq)
 	if err != nil {
-		return err
+		return newRequestError("error copying resource", dreq, nil, attempts, err)
 	}
 	defer func() {
 		io.Copy(ioutil.Discard, dresp.Body)
 		_ = dresp.Body.Close()
 	}()
 	if dresp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error copying resource: %s", dresp.Status)
+		return newRequestError("error copying resource", dreq, dresp, attempts, nil)
 	}
+	service.summary.addWritten(1)
 
 	return nil
 }
 
-func doDelete(destURL, id string) error {
+func (service *SyncService) syncContent(id string) (bool, error) {
+	source, differs, err := service.compareContent(id)
+	if err != nil || !differs {
+		return false, err
+	}
+
+	du := service.DestURL
+	if !strings.HasSuffix(du, "/") {
+		du = du + "/"
+	}
+
+	dreq, err := http.NewRequest("PUT", fmt.Sprintf("%s%s", du, id), bytes.NewReader(source))
+	if err != nil {
+		return false, err
+	}
+	dreq = dreq.WithContext(service.destContext())
+	dreq.Header.Set("Content-type", "application/json")
+	dresp, attempts, err := service.client.send(dreq)
+	if err != nil {
+		return false, newRequestError("error updating resource", dreq, nil, attempts, err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, dresp.Body)
+		_ = dresp.Body.Close()
+	}()
+	if dresp.StatusCode != http.StatusOK {
+		return false, newRequestError("error updating resource", dreq, dresp, attempts, nil)
+	}
+	service.summary.addWritten(1)
+	return true, nil
+}
+
+func (service *SyncService) contentDiffers(id string) (bool, error) {
+	_, differs, err := service.compareContent(id)
+	return differs, err
+}
+
+func (service *SyncService) compareContent(id string) ([]byte, bool, error) {
+	source, err := service.client.doGet(service.sourceContext(), service.SourceURL, id)
+	if err != nil {
+		return nil, false, err
+	}
+	dest, err := service.client.doGet(service.destContext(), service.DestURL, id)
+	if err != nil {
+		return nil, false, err
+	}
+	service.summary.addRead(2)
+	return source, contentHash(source) != contentHash(dest), nil
+}
+
+func (c *Client) doGet(ctx context.Context, baseURL, id string) ([]byte, error) {
+	u := baseURL
+	if !strings.HasSuffix(u, "/") {
+		u = u + "/"
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s%s", u, id), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	resp, attempts, err := c.send(req)
+	if err != nil {
+		return nil, newRequestError("error reading resource", req, nil, attempts, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := newRequestError("error reading resource", req, resp, attempts, nil)
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, err
+	}
+	return ioutil.ReadAll(resp.Body)
+}
 
-	du := destURL
+// contentHash returns a hash of the canonical form of a JSON body, so that
+// differences in key order or whitespace are not treated as changes. Numbers
+// are kept as written, so that large integers are not rounded. Bodies that are
+// not valid JSON are hashed as-is.
+func contentHash(body []byte) string {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err == nil && !dec.More() {
+		if canonical, err := json.Marshal(v); err == nil {
+			body = canonical
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func (service *SyncService) delete(id string) error {
+
+	du := service.DestURL
 	if !strings.HasSuffix(du, "/") {
 		du = du + "/"
 	}
@@ -479,37 +958,48 @@ func doDelete(destURL, id string) error {
 	if err != nil {
 		return err
 	}
-	dresp, err := HttpClient.Do(dreq)
+	dreq = dreq.WithContext(service.destContext())
+	dresp, attempts, err := service.client.send(dreq)
 	if err != nil {
-		return err
+		return newRequestError("error deleting resource", dreq, nil, attempts, err)
 	}
 	defer func() {
 		io.Copy(ioutil.Discard, dresp.Body)
 		_ = dresp.Body.Close()
 	}()
-	if dresp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error deleting resource: %s", dresp.Status)
+	// a retried DELETE may find the resource already gone
+	if dresp.StatusCode != http.StatusOK && dresp.StatusCode != http.StatusNoContent && dresp.StatusCode != http.StatusNotFound {
+		return newRequestError("error deleting resource", dreq, dresp, attempts, nil)
 	}
+	service.summary.addWritten(1)
 	return nil
 }
 
 func (rp *resourcePutter) putAllBinary(msgs <-chan *binaryMsg, failChan chan []byte, wg *sync.WaitGroup) {
 	for msg := range msgs {
+		rp.progress.increment()
 		putURL, err := generatePutURL(*msg.id, rp.baseURL)
 
 		if err != nil {
-			log.Errorf("generatePutURL Error=%v", err.Error())
+			rp.client.log.Errorf("generatePutURL Error=%v", err.Error())
+			rp.client.reportFailure(rp.failures, opCopy, *msg.id, err)
+			rp.summary.addFailed(opCopy)
 			if failChan != nil {
 				failChan <- []byte(*msg.id)
 			}
 			continue
 		}
 
-		var r io.Reader = *msg.body
-		err = rp.put(putURL.String(), r, msg.ct)
+		// read the whole body so that the PUT can be retried
+		data, err := ioutil.ReadAll(*msg.body)
+		if err == nil {
+			err = rp.put(putURL.String(), bytes.NewReader(data), msg.ct)
+		}
 
 		if err != nil {
-			log.Errorf("PUT putURL=%v, Error=%v", putURL, err.Error())
+			rp.client.log.Errorf("PUT putURL=%v, Error=%v", putURL, err.Error())
+			rp.client.reportFailure(rp.failures, opCopy, *msg.id, err)
+			rp.summary.addFailed(opCopy)
 			if failChan != nil {
 				failChan <- []byte(*msg.id)
 			}
@@ -517,6 +1007,8 @@ func (rp *resourcePutter) putAllBinary(msgs <-chan *binaryMsg, failChan chan []b
 			continue
 		}
 		(*msg.body).Close()
+		rp.summary.addWritten(1)
+		rp.summary.addOp(opCopy)
 	}
 	wg.Done()
 }
@@ -535,10 +1027,13 @@ func generatePutURL(id string, baseURL string) (putURL *url.URL, err error) {
 
 func (rp *resourcePutter) putAll(resources <-chan resource, failChan chan []byte) error {
 	for r := range resources {
+		rp.progress.increment()
 		id := r[rp.idProperty]
 		idStr, ok := id.(string)
 		if !ok {
-			log.Info("unable to extract id property from resource, skipping")
+			rp.client.log.Info("unable to extract id property from resource, skipping")
+			rp.summary.addSkipped(1)
+			continue
 		}
 
 		msg, err := json.Marshal(r)
@@ -550,11 +1045,21 @@ func (rp *resourcePutter) putAll(resources <-chan resource, failChan chan []byte
 			return err
 		}
 		err = rp.put(u.String(), bytes.NewReader(msg), "application/json")
-		if err != nil {
+		if err == nil {
+			rp.summary.addWritten(1)
+			rp.summary.addOp(opPut)
+		} else {
+			rp.summary.addFailed(opPut)
+			if rp.failures != nil {
+				rp.client.log.Errorf("PUT putURL=%v, Error=%v", u, err.Error())
+				if err := rp.failures.reportResource(opPut, idStr, msg, err); err != nil {
+					return err
+				}
+			}
 			if failChan != nil {
 				failChan <- msg
-			} else {
-				return err
+			} else if rp.failures == nil {
+				return &ResourceError{Op: opPut, ID: idStr, Err: err}
 			}
 		}
 	}
@@ -566,149 +1071,295 @@ func (rp *resourcePutter) put(url string, data io.Reader, contentType string) (e
 	if err != nil {
 		return
 	}
-	req.Header.Set("User-Agent", Useragent)
 	req.Header.Set("Content-Type", contentType)
+	ctx := rp.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if rp.observeOnly {
+		req = req.WithContext(withRateObserver(ctx, rp.limiter))
+	} else {
+		req = req.WithContext(withRateLimiter(ctx, rp.limiter))
+	}
 
 	if rp.user != "" && rp.pass != "" {
 		req.SetBasicAuth(rp.user, rp.pass)
 	}
-	resp, err := HttpClient.Do(req)
-	if err != nil {
-		return
-	}
-	contents, err := ioutil.ReadAll(resp.Body)
+	resp, attempts, err := rp.client.send(req)
 	if err != nil {
-		return
+		return newRequestError("http fail", req, nil, attempts, err)
 	}
-	resp.Body.Close()
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
 	if resp.StatusCode > 299 {
-		return fmt.Errorf("http fail: %v :\n%s\n", resp.Status, contents)
+		return newRequestError("http fail", req, resp, attempts, nil)
 	}
 
 	return
 }
 
-func GetAllRest(baseURL string, throttle int) (err error) {
-	messages := make(chan string, 128)
+// GetAllRest writes every resource of the collection at baseURL to out as JSON Lines with DefaultClient.
+func GetAllRest(ctx context.Context, baseURL string, limiter *RateLimiter, failures *FailureReport, out io.Writer) (*Summary, error) {
+	return DefaultClient.GetAllRest(ctx, baseURL, limiter, failures, out)
+}
+
+// GetAllRest writes every resource of the collection at baseURL to out as JSON Lines, one compact JSON document per
+// line, as read back by PutAllRest.
+func (c *Client) GetAllRest(ctx context.Context, baseURL string, limiter *RateLimiter, failures *FailureReport, out io.Writer) (*Summary, error) {
+	w := bufio.NewWriter(out)
+	summary, err := c.dumpAll(ctx, baseURL, limiter, failures, false, func(r *rawResource) error {
+		if _, err := w.Write(r.body); err != nil {
+			return err
+		}
+		return w.WriteByte('\n')
+	})
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	return summary, err
+}
+
+// rawResource is a resource as read, along with its Content-Type.
+type rawResource struct {
+	id          string
+	contentType string
+	body        []byte
+}
+
+// dumpAll passes every resource of the collection at baseURL to write, stopping at the first error it returns, or
+// once the resources in flight are written when ctx is done. A *ResourceError returned by write is recorded in failures
+// when given, rather than stopping the dump. Unless raw is set, the resources are compacted as JSON.
+func (c *Client) dumpAll(ctx context.Context, baseURL string, limiter *RateLimiter, failures *FailureReport, raw bool, write func(*rawResource) error) (*Summary, error) {
+	summary := c.newSummary()
+	reqCtx, cancel := c.drainContext(ctx)
+	defer cancel()
+	messages := make(chan *rawResource, 128)
+	prog := c.newProgress(opGet, 0)
+	defer prog.finish("Done gets")
+	// stop is called when a resource cannot be written, so that no more are fetched
+	fetchCtx, stop := context.WithCancel(ctx)
+	defer stop()
 
-	go getAllRest(baseURL, throttle, messages)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.getAllRest(fetchCtx, reqCtx, baseURL, limiter, failures, summary, prog, raw, messages)
+	}()
 
+	// after a write error, drain the resources in flight so that the fetchers are done before returning it
+	var writeErr error
 	for msg := range messages {
-		log.Info(msg)
+		if writeErr != nil {
+			continue
+		}
+		if err := write(msg); err != nil {
+			// a resource that cannot be written is recorded like one that cannot be read
+			if rerr, ok := err.(*ResourceError); ok {
+				summary.addFailed(opGet)
+				if failures != nil {
+					c.log.Errorf("Failed to write ID=%v, Error=%v", msg.id, rerr.Err)
+					err = failures.Report(opGet, msg.id, rerr.Err)
+				}
+			}
+			if err != nil {
+				writeErr = err
+				stop()
+			}
+			continue
+		}
+		summary.addWritten(1)
 	}
-	return err
+	err := <-errs
+	if writeErr != nil {
+		return summary.finish(), writeErr
+	}
+	if err != nil {
+		return summary.finish(), err
+	}
+	return summary.finish(), ctx.Err()
 }
 
-func getAllRest(baseURL string, throttle int, messages chan string) (err error) {
-	log.Infof("baseURL=%v throttle=%v", baseURL, throttle)
+// getAllRest sends the resources of the collection at baseURL to messages, and closes it.
+func (c *Client) getAllRest(ctx context.Context, reqCtx context.Context, baseURL string, limiter *RateLimiter, failures *FailureReport, summary *Summary, prog *progress, raw bool, messages chan *rawResource) error {
+	defer close(messages)
+	c.log.Infof("baseURL=%v throttle=%v", baseURL, limiter.Limit())
 	if baseURL == "" {
 		return errors.New("baseURL must be provided")
 	}
 	if !strings.HasSuffix(baseURL, "/") {
 		baseURL = baseURL + "/"
 	}
-	if throttle < 1 {
-		log.Fatalf("Invalid throttle %d", throttle)
-	}
-	ticker := time.NewTicker(time.Second / time.Duration(throttle))
-
-	go func() {
-		fetchAll(baseURL, messages, ticker)
-		close(messages)
-	}()
-	return err
+	return c.fetchAll(ctx, reqCtx, baseURL, messages, limiter, failures, summary, prog, raw)
 }
 
-func fetchAll(baseURL string, messages chan<- string, ticker *time.Ticker) {
+// fetchAll stops listing ids when ctx is done or a resource fails, and fetches the ones listed with reqCtx.
+func (c *Client) fetchAll(ctx context.Context, reqCtx context.Context, baseURL string, messages chan<- *rawResource, limiter *RateLimiter, failures *FailureReport, summary *Summary, prog *progress, raw bool) error {
+	listCtx, stop := context.WithCancel(ctx)
+	defer stop()
 	ids := make(chan *string, 128)
-	go fetchIDList(baseURL, ids)
+	listErr := make(chan error, 1)
+	go func() {
+		listErr <- c.fetchIDList(listCtx, baseURL, ids, prog)
+	}()
 
 	readers := 32
 
-	Transport.MaxIdleConnsPerHost = readers
-
 	readWg := sync.WaitGroup{}
+	errs := make(chan error, 1)
 
 	for i := 0; i < readers; i++ {
 		readWg.Add(1)
 		go func(i int) {
-			fetchMessages(baseURL, messages, ids, ticker)
-			readWg.Done()
+			defer readWg.Done()
+			if err := c.fetchMessages(listCtx, reqCtx, baseURL, messages, ids, limiter, failures, summary, prog, raw); err != nil {
+				select {
+				case errs <- err:
+				default:
+				}
+				stop()
+			}
 		}(i)
 	}
 
 	readWg.Wait()
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+	if err := <-listErr; err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
 }
 
-func fetchIDList(baseURL string, ids chan<- *string) {
+// fetchIDList sends every id listed in the __ids of the collection to ids, in the format and following the pages set
+// with SetIDList, adding them to the total of prog, until ctx is done. It closes ids once done, and returns an
+// *IDListError if the ids cannot be listed.
+func (c *Client) fetchIDList(ctx context.Context, baseURL string, ids chan<- *string, prog *progress) error {
+	defer close(ids)
 
 	u, err := url.Parse(baseURL)
 	if err != nil {
-		log.Fatal(err)
+		return &IDListError{URL: baseURL, Err: err}
 	}
 	u, err = u.Parse("./__ids")
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	req, err := http.NewRequest("GET", u.String(), nil)
-	if err != nil {
-		panic(err)
+		return &IDListError{URL: baseURL, Err: err}
 	}
-	req.Header.Set("User-Agent", Useragent)
-	resp, err := HttpClient.Do(req)
-	if err != nil {
-		panic(err)
-	}
-	defer resp.Body.Close()
 
-	type listEntry struct {
-		ID string `json:"id"`
-	}
-
-	var le listEntry
-	dec := json.NewDecoder(resp.Body)
-	for {
-		err = dec.Decode(&le)
+	err = c.idLists.idListFor(baseURL).listIDs(u, func(pageURL *url.URL) (*http.Response, error) {
+		req, err := http.NewRequest("GET", pageURL.String(), nil)
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			log.Fatal(err)
+			return nil, &IDListError{URL: pageURL.String(), Err: err}
 		}
-		var id = le.ID
-		ids <- &id
+		req = req.WithContext(ctx)
+		resp, attempts, err := c.send(req)
+		if err != nil {
+			return nil, &IDListError{URL: pageURL.String(), Err: newRequestError("error fetching ids", req, nil, attempts, err)}
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := newRequestError("error fetching ids", req, resp, attempts, nil)
+			resp.Body.Close()
+			return nil, &IDListError{URL: pageURL.String(), Err: err}
+		}
+		return resp, nil
+	}, func(id string) error {
+		prog.addTotal(1)
+		select {
+		case ids <- &id:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if _, ok := err.(*IDListError); err == nil || ok || err == ctx.Err() {
+		return err
 	}
-
-	close(ids)
+	return &IDListError{URL: u.String(), Err: err}
 }
 
-func fetchMessages(baseURL string, messages chan<- string, ids <-chan *string, ticker *time.Ticker) {
+// fetchMessages fetches the resources listed in ids with reqCtx, skipping the rest once ctx is done. It stops at the
+// first failure, returning a *ResourceError, unless given a failure report to record it in. Unless raw is set,
+// resources that are not JSON fail.
+func (c *Client) fetchMessages(ctx context.Context, reqCtx context.Context, baseURL string, messages chan<- *rawResource, ids <-chan *string, limiter *RateLimiter, failures *FailureReport, summary *Summary, prog *progress, raw bool) error {
+	fail := func(id string, err error) error {
+		summary.addFailed(opGet)
+		prog.increment()
+		if failures == nil {
+			return &ResourceError{Op: opGet, ID: id, Err: err}
+		}
+		c.log.Errorf("Failed to fetch ID=%v, Error=%v", id, err)
+		return failures.Report(opGet, id, err)
+	}
 	for id := range ids {
-		<-ticker.C
+		if ctx.Err() != nil {
+			continue
+		}
 		req, err := http.NewRequest("GET", strings.Join([]string{baseURL, *id}, ""), nil)
 		if err != nil {
-			panic(err)
+			if err := fail(*id, err); err != nil {
+				return err
+			}
+			continue
 		}
-		req.Header.Set("User-Agent", Useragent)
-		resp, err := HttpClient.Do(req)
+		req = req.WithContext(withRateLimiter(reqCtx, limiter))
+		resp, attempts, err := c.send(req)
 		if err != nil {
-			panic(err)
+			if err := fail(*id, newRequestError("error fetching resource", req, nil, attempts, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := fail(*id, newRequestError("error fetching resource", req, resp, attempts, nil))
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+			continue
 		}
 		data, err := ioutil.ReadAll(resp.Body)
 		resp.Body.Close()
 		if err != nil {
-			panic(err)
+			if err := fail(*id, err); err != nil {
+				return err
+			}
+			continue
 		}
-		messages <- string(data)
+		if !raw {
+			compact := new(bytes.Buffer)
+			if err := json.Compact(compact, data); err != nil {
+				if err := fail(*id, fmt.Errorf("invalid JSON resource: %v", err)); err != nil {
+					return err
+				}
+				continue
+			}
+			data = compact.Bytes()
+		}
+		summary.addRead(1)
+		summary.addOp(opGet)
+		prog.increment()
+		messages <- &rawResource{id: *id, contentType: resp.Header.Get("Content-Type"), body: data}
 	}
+	return nil
 }
 
 type resource map[string]interface{}
 
 type resourcePutter struct {
+	client     *Client
+	ctx        context.Context
 	baseURL    string
 	idProperty string
 	user       string
 	pass       string
+	limiter    *RateLimiter
+	failures   *FailureReport
+	summary    *Summary
+	progress   *progress
+	// observeOnly makes PUTs report their outcome to limiter without waiting for it, when it already paces the reads
+	// feeding them
+	observeOnly bool
 }
@@ -2,15 +2,22 @@ package restutil
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 const (
@@ -32,7 +39,7 @@ func TestPutAllBinaryRest_allOK(t *testing.T) {
 	user := "user"
 	pass := "pass"
 	conns := 1
-	dumpFailed := false
+	var dumpFailed io.Writer
 	m := NewMockHttpServer()
 	m.fResp <- Ids
 	for i := 1; i < 11; i++ {
@@ -41,7 +48,7 @@ func TestPutAllBinaryRest_allOK(t *testing.T) {
 
 	defer m.Close()
 
-	err := PutAllBinaryRest(m.from.URL, m.to.URL, user, pass, conns, 10, dumpFailed)
+	_, err := PutAllBinaryRest(context.Background(), m.from.URL, m.to.URL, user, pass, conns, NewRateLimiter(10, 0, 0), dumpFailed, nil)
 	assert.NoError(t, err)
 	freqs := m.getFromReqs()
 	assert.Equal(t, 11, len(freqs))
@@ -68,7 +75,7 @@ func TestPutAllBinaryRest_allOKAndNoThrottling(t *testing.T) {
 	user := "user"
 	pass := "pass"
 	conns := 1
-	dumpFailed := false
+	var dumpFailed io.Writer
 	m := NewMockHttpServer()
 	m.fResp <- Ids
 	for i := 1; i < 11; i++ {
@@ -77,7 +84,7 @@ func TestPutAllBinaryRest_allOKAndNoThrottling(t *testing.T) {
 
 	defer m.Close()
 
-	err := PutAllBinaryRest(m.from.URL, m.to.URL, user, pass, conns, 0, dumpFailed)
+	_, err := PutAllBinaryRest(context.Background(), m.from.URL, m.to.URL, user, pass, conns, nil, dumpFailed, nil)
 	assert.NoError(t, err)
 	freqs := m.getFromReqs()
 	assert.Equal(t, 11, len(freqs))
@@ -103,7 +110,7 @@ func TestPutAllBinaryRest_allOKWithMultipleConns(t *testing.T) {
 	user := "user"
 	pass := "pass"
 	conns := 5
-	dumpFailed := false
+	var dumpFailed io.Writer
 	m := NewMockHttpServer()
 	m.fResp <- Ids
 	for i := 1; i < 11; i++ {
@@ -112,7 +119,7 @@ func TestPutAllBinaryRest_allOKWithMultipleConns(t *testing.T) {
 
 	defer m.Close()
 
-	err := PutAllBinaryRest(m.from.URL, m.to.URL, user, pass, conns, 5, dumpFailed)
+	_, err := PutAllBinaryRest(context.Background(), m.from.URL, m.to.URL, user, pass, conns, NewRateLimiter(5, 0, 0), dumpFailed, nil)
 	assert.NoError(t, err)
 	freqs := m.getFromReqs()
 	assert.Equal(t, 11, len(freqs))
@@ -127,7 +134,7 @@ func TestPutAllBinaryRest_PutFails(t *testing.T) {
 	user := "user"
 	pass := "pass"
 	conns := 1
-	dumpFailed := true
+	dumpFailed := new(bytes.Buffer)
 	m := NewMockHttpServer()
 	m.fResp <- Ids
 	m.toError = true
@@ -136,7 +143,7 @@ func TestPutAllBinaryRest_PutFails(t *testing.T) {
 	}
 
 	defer m.from.Close()
-	err := PutAllBinaryRest(m.from.URL, m.to.URL, user, pass, conns, 10, dumpFailed)
+	_, err := PutAllBinaryRest(context.Background(), m.from.URL, m.to.URL, user, pass, conns, NewRateLimiter(10, 0, 0), dumpFailed, nil)
 	assert.NoError(t, err)
 	freqs := m.getFromReqs()
 	assert.Equal(t, 11, len(freqs))
@@ -145,13 +152,14 @@ func TestPutAllBinaryRest_PutFails(t *testing.T) {
 	treqs, tbdy := m.getToReqs()
 	assert.Equal(t, 10, len(treqs))
 	assert.Equal(t, 10, len(tbdy))
+	assert.Equal(t, "UUID-1\nUUID-2\nUUID-3\nUUID-4\nUUID-5\nUUID-6\nUUID-7\nUUID-8\nUUID-9\nUUID-10\n", dumpFailed.String())
 }
 
 func TestPutAllBinaryRest_PutFailsAndDoesNotDump(t *testing.T) {
 	user := "user"
 	pass := "pass"
 	conns := 1
-	dumpFailed := false
+	var dumpFailed io.Writer
 	m := NewMockHttpServer()
 	m.fResp <- Ids
 	m.toError = true
@@ -160,7 +168,7 @@ func TestPutAllBinaryRest_PutFailsAndDoesNotDump(t *testing.T) {
 	}
 
 	defer m.from.Close()
-	err := PutAllBinaryRest(m.from.URL, m.to.URL, user, pass, conns, 10, dumpFailed)
+	_, err := PutAllBinaryRest(context.Background(), m.from.URL, m.to.URL, user, pass, conns, NewRateLimiter(10, 0, 0), dumpFailed, nil)
 	assert.NoError(t, err)
 	freqs := m.getFromReqs()
 	assert.Equal(t, 11, len(freqs))
@@ -171,6 +179,557 @@ func TestPutAllBinaryRest_PutFailsAndDoesNotDump(t *testing.T) {
 	assert.Equal(t, 10, len(tbdy))
 }
 
+func TestPutAllBinaryRest_InvalidIDFails(t *testing.T) {
+	source := newFakeCollection(map[string]string{"UUID-1": `{"id":"UUID-1"}`, "bad%zz": `{}`})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+
+	report := new(bytes.Buffer)
+	summary, err := PutAllBinaryRest(context.Background(), source.URL, dest.URL, "", "", 2, nil, nil, &FailureReport{enc: json.NewEncoder(report)})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), summary.Written)
+	assert.Equal(t, int64(1), summary.Failed)
+	assert.Equal(t, []string{"UUID-1"}, dest.puts())
+	var f Failure
+	if assert.NoError(t, json.Unmarshal(report.Bytes(), &f)) {
+		assert.Equal(t, "bad%zz", f.ID)
+		assert.Equal(t, opCopy, f.Op)
+	}
+}
+
+func TestPutAllBinaryRest_CancelStartsNoNewRequests(t *testing.T) {
+	resources := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		resources[fmt.Sprintf("UUID-%03d", i)] = `{}`
+	}
+	source := newFakeCollection(resources)
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	gets := 0
+	cancelling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/__ids" {
+			mu.Lock()
+			if gets++; gets == 5 {
+				cancel()
+			}
+			mu.Unlock()
+		}
+		source.handle(w, r)
+	}))
+	defer cancelling.Close()
+
+	conns := 2
+	_, err := PutAllBinaryRest(ctx, cancelling.URL, dest.URL, "", "", conns, nil, nil, nil)
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, len(source.requests("GET")) <= 1+5+conns, "made %d GETs after cancelling", len(source.requests("GET")))
+	assert.True(t, len(dest.puts()) <= 5+conns, "made %d PUTs after cancelling", len(dest.puts()))
+}
+
+func TestSyncIDs_CompareContentUpdatesChanged(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1","name":"same","tags":["a","b"]}`,
+		"UUID-2": `{"id":"UUID-2","name":"new"}`,
+		"UUID-3": `{"id":"UUID-3"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{
+		"UUID-1": `{"tags":["a","b"], "name":"same", "id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2","name":"old"}`,
+	})
+	defer dest.Close()
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1", "UUID-2", "UUID-3"},
+		DestIDsRetriever:   staticIDListRetriever{"UUID-1", "UUID-2"},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  2,
+		CompareContent:     true,
+	}
+
+	_, err := SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-2", "UUID-3"}, dest.puts())
+	assert.Equal(t, `{"id":"UUID-2","name":"new"}`, dest.get("UUID-2"))
+}
+
+func TestSyncIDs_WithoutCompareContentIgnoresShared(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1","name":"new"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1","name":"old"}`,
+	})
+	defer dest.Close()
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1"},
+		DestIDsRetriever:   staticIDListRetriever{"UUID-1"},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+	}
+
+	_, err := SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.Empty(t, dest.puts())
+	assert.Equal(t, 0, len(dest.requests("GET")))
+}
+
+func TestSyncIDs_DestLimiterPacesWrites(t *testing.T) {
+	resources := map[string]string{}
+	var ids []string
+	for i := 1; i <= 5; i++ {
+		id := fmt.Sprintf("UUID-%d", i)
+		resources[id] = fmt.Sprintf(`{"id":"%s"}`, id)
+		ids = append(ids, id)
+	}
+	source := newFakeCollection(resources)
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever(ids),
+		DestIDsRetriever:   staticIDListRetriever{},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  5,
+		DestLimiter:        NewRateLimiter(20, 0, 0),
+	}
+
+	start := time.Now()
+	_, err := SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.Equal(t, ids, dest.puts())
+	// the first PUT goes through at once, and each of the other four waits 50ms
+	assert.True(t, time.Since(start) >= 190*time.Millisecond, "PUTs were not paced, took %v", time.Since(start))
+}
+
+func TestSyncIDs_DryRunIssuesNoWrites(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1","name":"new"}`,
+		"UUID-2": `{"id":"UUID-2"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1","name":"old"}`,
+		"UUID-3": `{"id":"UUID-3"}`,
+	})
+	defer dest.Close()
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1", "UUID-2"},
+		DestIDsRetriever:   staticIDListRetriever{"UUID-1", "UUID-3"},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+		Deletes:            true,
+		CompareContent:     true,
+		DryRun:             true,
+	}
+
+	out := new(bytes.Buffer)
+	service.PlanOutput = out
+	_, err := SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.Empty(t, dest.requests("PUT"))
+	assert.Empty(t, dest.requests("DELETE"))
+	assert.Equal(t, 1, len(dest.requests("GET")))
+
+	var plan SyncPlan
+	assert.NoError(t, json.NewDecoder(out).Decode(&plan))
+	assert.Equal(t, []string{"UUID-2"}, plan.Create)
+	assert.Equal(t, []string{"UUID-1"}, plan.Update)
+	assert.Equal(t, []string{"UUID-3"}, plan.Delete)
+}
+
+func TestSyncIDs_CancelCompletesRequestsInFlight(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2"}`,
+		"UUID-3": `{"id":"UUID-3"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		dest.handle(w, r)
+	}))
+	defer cancelling.Close()
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1", "UUID-2", "UUID-3"},
+		DestIDsRetriever:   staticIDListRetriever{},
+		SourceURL:          source.URL,
+		DestURL:            cancelling.URL,
+		MaxConcurrentReqs:  1,
+	}
+
+	summary, err := SyncIDs(ctx, service)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, len(dest.puts()))
+	assert.Equal(t, map[string]int64{opCreate: 1}, summary.Operations)
+}
+
+func TestApplyPlan_PerformsPlannedOperations(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1","name":"new"}`,
+		"UUID-2": `{"id":"UUID-2"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1","name":"old"}`,
+		"UUID-3": `{"id":"UUID-3"}`,
+	})
+	defer dest.Close()
+
+	service := &SyncService{
+		SourceURL:         source.URL,
+		DestURL:           dest.URL,
+		MaxConcurrentReqs: 1,
+	}
+	plan := &SyncPlan{
+		Create: []string{"UUID-2"},
+		Update: []string{"UUID-1"},
+		Delete: []string{"UUID-3"},
+	}
+
+	_, err := ApplyPlan(context.Background(), service, plan)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-1", "UUID-2"}, dest.puts())
+	assert.Equal(t, `{"id":"UUID-1","name":"new"}`, dest.get("UUID-1"))
+	assert.Equal(t, 1, len(dest.requests("DELETE")))
+	assert.Equal(t, "", dest.get("UUID-3"))
+}
+
+func TestSyncIDs_IDListFailureIsReturned(t *testing.T) {
+	source := newFakeCollection(map[string]string{})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+	dest.fail("GET", "__ids", 10)
+
+	c := NewClient(WithRetryPolicy(&RetryPolicy{}))
+	service := &SyncService{
+		SourceIDsRetriever: c.IDListRetriever("", source.URL+"/"),
+		DestIDsRetriever:   c.IDListRetriever("", dest.URL+"/"),
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+	}
+
+	_, err := c.SyncIDs(context.Background(), service)
+	if assert.IsType(t, &IDListError{}, err) {
+		assert.Equal(t, dest.URL, err.(*IDListError).URL)
+	}
+}
+
+func TestSyncIDs_IDFileFailureIsNotAnIDListError(t *testing.T) {
+	c := NewClient()
+	service := &SyncService{
+		SourceIDsRetriever: c.IDListRetriever("non_existing_file", "http://localhost/source/"),
+		DestIDsRetriever:   staticIDListRetriever{},
+		SourceURL:          "http://localhost/source",
+		DestURL:            "http://localhost/dest",
+		MaxConcurrentReqs:  1,
+	}
+
+	_, err := c.SyncIDs(context.Background(), service)
+	if assert.Error(t, err) {
+		_, ok := err.(*IDListError)
+		assert.False(t, ok, "expected no *IDListError for the ids read from a file")
+		assert.Contains(t, err.Error(), "file=non_existing_file")
+	}
+}
+
+func TestSyncIDs_DeleteLimitsAbortBeforeChanges(t *testing.T) {
+	tests := []struct {
+		maxDeletes     int
+		maxDeleteRatio float64
+		expectError    bool
+	}{
+		{0, 0, false},
+		{2, 0, false},
+		{1, 0, true},
+		{0, 0.5, false},
+		{0, 0.4, true},
+	}
+
+	for _, test := range tests {
+		source := newFakeCollection(map[string]string{
+			"UUID-1": `{"id":"UUID-1"}`,
+		})
+		dest := newFakeCollection(map[string]string{
+			"UUID-2": `{"id":"UUID-2"}`,
+			"UUID-3": `{"id":"UUID-3"}`,
+			"UUID-4": `{"id":"UUID-4"}`,
+			"UUID-5": `{"id":"UUID-5"}`,
+		})
+
+		service := &SyncService{
+			SourceIDsRetriever: staticIDListRetriever{"UUID-1", "UUID-2", "UUID-3"},
+			DestIDsRetriever:   staticIDListRetriever{"UUID-2", "UUID-3", "UUID-4", "UUID-5"},
+			SourceURL:          source.URL,
+			DestURL:            dest.URL,
+			MaxConcurrentReqs:  1,
+			Deletes:            true,
+			MaxDeletes:         test.maxDeletes,
+			MaxDeleteRatio:     test.maxDeleteRatio,
+		}
+
+		_, err := SyncIDs(context.Background(), service)
+		if test.expectError {
+			assert.Error(t, err, "maxDeletes=%d maxDeleteRatio=%v", test.maxDeletes, test.maxDeleteRatio)
+			assert.Empty(t, dest.requests("PUT"))
+			assert.Empty(t, dest.requests("DELETE"))
+		} else {
+			assert.NoError(t, err, "maxDeletes=%d maxDeleteRatio=%v", test.maxDeletes, test.maxDeleteRatio)
+			assert.Equal(t, 2, len(dest.requests("DELETE")))
+		}
+		source.Close()
+		dest.Close()
+	}
+}
+
+func TestSyncIDs_DeletesAreRetried(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, RetryableStatus: []int{http.StatusServiceUnavailable}}
+
+	source := newFakeCollection(map[string]string{})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2"}`,
+		"UUID-3": `{"id":"UUID-3"}`,
+	})
+	defer dest.Close()
+	dest.fail("DELETE", "UUID-2", 2)
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{},
+		DestIDsRetriever:   staticIDListRetriever{"UUID-1", "UUID-2", "UUID-3"},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  3,
+		Deletes:            true,
+	}
+
+	_, err := SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(dest.requests("DELETE")))
+	assert.Equal(t, "", dest.get("UUID-2"))
+}
+
+func TestSyncIDs_DryRunRequiresPlanOutput(t *testing.T) {
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1"},
+		DestIDsRetriever:   staticIDListRetriever{},
+		MaxConcurrentReqs:  1,
+		DryRun:             true,
+	}
+
+	_, err := SyncIDs(context.Background(), service)
+	assert.EqualError(t, err, "a plan output must be provided for a dry run")
+}
+
+func TestSyncIDs_RetriesOverrideRetryPolicy(t *testing.T) {
+	source := newFakeCollection(map[string]string{"UUID-1": `{"id":"UUID-1"}`})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+	dest.fail("PUT", "UUID-1", 2)
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1"},
+		DestIDsRetriever:   staticIDListRetriever{},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+		Retries:            2,
+	}
+
+	client := NewClient(WithRetryPolicy(&RetryPolicy{BaseDelay: time.Millisecond, RetryableStatus: []int{http.StatusServiceUnavailable}}))
+	_, err := client.SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(dest.requests("PUT")))
+	assert.Equal(t, `{"id":"UUID-1"}`, dest.get("UUID-1"))
+}
+
+func TestSyncIDs_DeleteFailsAfterRetries(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, RetryableStatus: []int{http.StatusServiceUnavailable}}
+
+	source := newFakeCollection(map[string]string{})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+	})
+	defer dest.Close()
+	dest.fail("DELETE", "UUID-1", 3)
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{},
+		DestIDsRetriever:   staticIDListRetriever{"UUID-1"},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+		Deletes:            true,
+	}
+
+	_, err := SyncIDs(context.Background(), service)
+	assert.Error(t, err)
+	assert.Equal(t, 3, len(dest.requests("DELETE")))
+}
+
+func TestContentHash_IgnoresKeyOrderAndWhitespace(t *testing.T) {
+	assert.Equal(t, contentHash([]byte(`{"a":1,"b":[1,2]}`)), contentHash([]byte(`{ "b": [1, 2],
+	"a": 1 }`)))
+	assert.NotEqual(t, contentHash([]byte(`{"a":1}`)), contentHash([]byte(`{"a":2}`)))
+	assert.NotEqual(t, contentHash([]byte(`{"a":9007199254740993}`)), contentHash([]byte(`{"a":9007199254740992}`)))
+	assert.NotEqual(t, contentHash([]byte("not json")), contentHash([]byte("not  json")))
+}
+
+func TestGetAllRest_WritesJSONLines(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{ "id": "UUID-1",
+	"name": "foo" }`,
+		"UUID-2": `{"id":"UUID-2"}`,
+	})
+	defer source.Close()
+
+	out := new(bytes.Buffer)
+	summary, err := GetAllRest(context.Background(), source.URL, nil, nil, out)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), summary.Written)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	sort.Strings(lines)
+	assert.Equal(t, []string{`{"id":"UUID-1","name":"foo"}`, `{"id":"UUID-2"}`}, lines)
+}
+
+func TestGetAllRest_ResourceFailureIsReturned(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID-2": `not json`,
+	})
+	defer source.Close()
+
+	_, err := GetAllRest(context.Background(), source.URL, nil, nil, new(bytes.Buffer))
+	if assert.IsType(t, &ResourceError{}, err) {
+		assert.Equal(t, opGet, err.(*ResourceError).Op)
+		assert.Equal(t, "UUID-2", err.(*ResourceError).ID)
+	}
+}
+
+func TestPutAllRest_DumpsFailedResources(t *testing.T) {
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+	dest.fail("PUT", "UUID-2", 1)
+
+	in := strings.NewReader(`{"id":"UUID-1"}` + "\n" + `{"id":"UUID-2"}`)
+	failed := new(bytes.Buffer)
+	summary, err := NewClient(WithRetryPolicy(&RetryPolicy{})).PutAllRest(context.Background(), dest.URL, "id", "", "", 1, nil, failed, nil, in)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), summary.Written)
+	assert.Equal(t, []string{"UUID-1"}, dest.puts())
+	assert.Equal(t, `{"id":"UUID-2"}`+"\n", failed.String())
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestGetAllRest_WriteErrorStopsFetching(t *testing.T) {
+	// large enough resources for the buffered output to be written before the last one is fetched
+	resources := make(map[string]string)
+	for i := 0; i < 500; i++ {
+		resources[fmt.Sprintf("UUID-%03d", i)] = `{"data":"` + strings.Repeat("x", 1000) + `"}`
+	}
+	source := newFakeCollection(resources)
+	defer source.Close()
+	// slow resources are still being fetched when the first one fails to be written
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/__ids" {
+			time.Sleep(10 * time.Millisecond)
+		}
+		source.handle(w, r)
+	}))
+	defer slow.Close()
+
+	report := new(bytes.Buffer)
+	failures := &FailureReport{enc: json.NewEncoder(report)}
+	summary, err := GetAllRest(context.Background(), slow.URL, nil, failures, failingWriter{})
+	assert.EqualError(t, err, "disk full")
+	assert.True(t, goroutinesStop("fetchMessages"), "fetchers still running after GetAllRest returned")
+	assert.Equal(t, 0, failures.Count())
+	assert.Equal(t, int64(0), summary.Failed)
+}
+
+func TestPutAllRest_ReturnsDumpFailedWriteError(t *testing.T) {
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+	in := new(bytes.Buffer)
+	for i := 1; i <= 10; i++ {
+		dest.fail("PUT", fmt.Sprintf("UUID-%d", i), 1)
+		fmt.Fprintf(in, "{\"id\":\"UUID-%d\"}\n", i)
+	}
+
+	_, err := NewClient(WithRetryPolicy(&RetryPolicy{})).PutAllRest(context.Background(), dest.URL, "id", "", "", 2, nil, failingWriter{}, nil, in)
+	assert.EqualError(t, err, "disk full")
+}
+
+func TestPutAllBinaryRest_ReturnsDumpFailedWriteError(t *testing.T) {
+	m := NewMockHttpServer()
+	m.fResp <- Ids
+	m.toError = true
+	for i := 1; i < 11; i++ {
+		m.fResp <- fmt.Sprintf(Payload, i)
+	}
+	defer m.Close()
+
+	_, err := PutAllBinaryRest(context.Background(), m.from.URL, m.to.URL, "", "", 1, nil, failingWriter{}, nil)
+	assert.EqualError(t, err, "disk full")
+}
+
+func TestDiffIDs_WritesIDsInOnlyOneCollection(t *testing.T) {
+	source := newFakeCollection(map[string]string{"UUID-1": `{}`, "UUID-2": `{}`})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{"UUID-2": `{}`, "UUID-3": `{}`})
+	defer dest.Close()
+
+	out := new(bytes.Buffer)
+	_, err := DiffIDs(context.Background(), source.URL+"/", dest.URL+"/", out)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"only-in-source":["UUID-1"],"only-in-destination":["UUID-3"]}`, out.String())
+}
+
+func TestDiffIDs_IDListFailureIsReturned(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{}
+
+	source := newFakeCollection(map[string]string{})
+	defer source.Close()
+	source.fail("GET", "__ids", 1)
+
+	_, err := DiffIDs(context.Background(), source.URL+"/", source.URL+"/", ioutil.Discard)
+	if assert.IsType(t, &IDListError{}, err) {
+		assert.Equal(t, http.StatusServiceUnavailable, err.(*IDListError).Err.(*RequestError).StatusCode)
+	}
+}
+
 type mockHttpServer struct {
 	sync.Mutex
 	fResp     chan string
@@ -253,3 +812,106 @@ func (m *mockHttpServer) Close() {
 	m.to.Close()
 	m.from.Close()
 }
+
+type staticIDListRetriever []string
+
+func (r staticIDListRetriever) Retrieve(ctx context.Context, ids chan<- string, errChan chan<- error) {
+	defer close(ids)
+	for _, id := range r {
+		if !sendID(ctx, ids, id) {
+			return
+		}
+	}
+}
+
+type fakeCollection struct {
+	*httptest.Server
+	sync.Mutex
+	resources map[string]string
+	reqs      []*http.Request
+	putIDs    []string
+	failures  map[string]int
+}
+
+func newFakeCollection(resources map[string]string) *fakeCollection {
+	c := &fakeCollection{resources: resources, failures: make(map[string]int)}
+	c.Server = httptest.NewServer(http.HandlerFunc(c.handle))
+	return c
+}
+
+func (c *fakeCollection) handle(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+	c.reqs = append(c.reqs, r)
+	id := strings.TrimPrefix(r.URL.Path, "/")
+	if c.failures[r.Method+" "+id] > 0 {
+		c.failures[r.Method+" "+id]--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		if id == "__ids" {
+			ids := make([]string, 0, len(c.resources))
+			for i := range c.resources {
+				ids = append(ids, i)
+			}
+			sort.Strings(ids)
+			for _, i := range ids {
+				fmt.Fprintf(w, "{\"id\":\"%s\"}\n", i)
+			}
+			return
+		}
+		body, found := c.resources[id]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	case "PUT":
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(r.Body)
+		c.resources[id] = buf.String()
+		c.putIDs = append(c.putIDs, id)
+		w.WriteHeader(http.StatusOK)
+	case "DELETE":
+		delete(c.resources, id)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// fail makes the next requests with method for id respond with 503 Service Unavailable.
+func (c *fakeCollection) fail(method string, id string, times int) {
+	c.Lock()
+	defer c.Unlock()
+	c.failures[method+" "+id] = times
+}
+
+func (c *fakeCollection) get(id string) string {
+	c.Lock()
+	defer c.Unlock()
+	return c.resources[id]
+}
+
+func (c *fakeCollection) puts() []string {
+	c.Lock()
+	defer c.Unlock()
+	ids := append([]string{}, c.putIDs...)
+	sort.Strings(ids)
+	return ids
+}
+
+func (c *fakeCollection) requests(method string) []*http.Request {
+	c.Lock()
+	defer c.Unlock()
+	var reqs []*http.Request
+	for _, r := range c.reqs {
+		if r.Method == method {
+			reqs = append(reqs, r)
+		}
+	}
+	return reqs
+}
@@ -0,0 +1,127 @@
+package restutil
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/cheggaaa/pb.v1"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProgressInterval is how often progress is logged when stderr is not a terminal, e.g. in a Kubernetes job, by the
+// clients not given an interval with WithProgressInterval.
+var ProgressInterval = 30 * time.Second
+
+// progress reports how far a pipeline has got, with its throughput and, once the total is known, the time left. On a
+// terminal it shows a progress bar on stderr, otherwise it logs a line every ProgressInterval.
+//
+// A nil *progress reports nothing.
+type progress struct {
+	name    string
+	total   int64
+	done    int64
+	start   time.Time
+	bar     *pb.ProgressBar
+	stop    chan struct{}
+	stopped sync.WaitGroup
+	// logger is the logger of the client, or the standard logger when nil
+	logger *log.Logger
+}
+
+// newProgress starts reporting the progress of the named pipeline over total items. A total of zero means unknown,
+// until items are added with addTotal.
+func (c *Client) newProgress(name string, total int) *progress {
+	p := &progress{name: name, total: int64(total), start: time.Now(), stop: make(chan struct{}), logger: c.log}
+	if isTerminal(os.Stderr) {
+		p.bar = pb.New(total)
+		p.bar.Output = os.Stderr
+		p.bar.ShowSpeed = true
+		p.bar.Start()
+		return p
+	}
+	p.stopped.Add(1)
+	go func() {
+		defer p.stopped.Done()
+		ticker := time.NewTicker(c.progressInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.log("Progress")
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// addTotal adds n items to the total, for pipelines learning it as they go, e.g. while reading __ids.
+func (p *progress) addTotal(n int) {
+	if p == nil {
+		return
+	}
+	total := atomic.AddInt64(&p.total, int64(n))
+	if p.bar != nil {
+		p.bar.SetTotal64(total)
+	}
+}
+
+// increment counts an item as done, whether it succeeded or not.
+func (p *progress) increment() {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.done, 1)
+	if p.bar != nil {
+		p.bar.Increment()
+	}
+}
+
+// finish stops reporting, and prints or logs msg.
+func (p *progress) finish(msg string) {
+	if p == nil {
+		return
+	}
+	if p.bar != nil {
+		p.bar.FinishPrint(msg)
+		return
+	}
+	close(p.stop)
+	p.stopped.Wait()
+	p.log(msg)
+}
+
+func (p *progress) log(msg string) {
+	done := atomic.LoadInt64(&p.done)
+	total := atomic.LoadInt64(&p.total)
+	elapsed := time.Since(p.start)
+	fields := log.Fields{
+		"pipeline": p.name,
+		"done":     done,
+		"elapsed":  elapsed.Round(time.Second).String(),
+	}
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(done) / elapsed.Seconds()
+		fields["rate"] = rate
+	}
+	if total > 0 {
+		fields["total"] = total
+		fields["percent"] = 100 * float64(done) / float64(total)
+		if rate > 0 && total > done {
+			fields["eta"] = (time.Duration(float64(total-done)/rate) * time.Second).Round(time.Second).String()
+		}
+	}
+	logger := p.logger
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+	logger.WithFields(fields).Info(msg)
+}
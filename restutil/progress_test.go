@@ -0,0 +1,36 @@
+package restutil
+
+import (
+	"bytes"
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgress_LogsRateAndETA(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	p := &progress{name: opPut, start: time.Now().Add(-10 * time.Second)}
+	p.addTotal(40)
+	for i := 0; i < 10; i++ {
+		p.increment()
+	}
+	p.log("Progress")
+
+	line := buf.String()
+	for _, field := range []string{"pipeline=put", "done=10", "total=40", "percent=25", "rate=", "eta=30s"} {
+		assert.True(t, strings.Contains(line, field), line)
+	}
+}
+
+func TestProgress_NilReportsNothing(t *testing.T) {
+	var p *progress
+	p.addTotal(1)
+	p.increment()
+	p.finish("Done")
+}
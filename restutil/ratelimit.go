@@ -0,0 +1,184 @@
+package restutil
+
+import (
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// adjustInterval is the minimum time between two changes of an adaptive rate, so that a burst of responses to
+	// requests made at the old rate only counts once.
+	adjustInterval = time.Second
+	// backoffFactor is applied to the rate when the target is overloaded.
+	backoffFactor = 0.5
+	// slowdownFactor is applied to the rate when the latency climbs above latencyFactor times its baseline.
+	slowdownFactor = 0.8
+	latencyFactor  = 2.0
+	// speedupFactor is applied to the rate when the target is healthy.
+	speedupFactor = 1.05
+	// latencyWeight is the weight of a new sample in the moving average of latencies.
+	latencyWeight = 0.2
+)
+
+// RateLimiter limits the rate of requests. When its floor is below its ceiling it adapts the rate to the target:
+// the rate is halved when the target responds with 429 or 503, lowered when latency climbs well above the best
+// seen so far, and slowly raised again while the target is healthy.
+//
+// A nil *RateLimiter does not limit anything.
+type RateLimiter struct {
+	mu         sync.Mutex
+	limiter    *rate.Limiter
+	floor      rate.Limit
+	ceiling    rate.Limit
+	latency    time.Duration
+	baseline   time.Duration
+	lastAdjust time.Time
+	now        func() time.Time
+}
+
+// NewRateLimiter returns a limiter starting at rps requests per second and adapting between floor and ceiling. A
+// floor or ceiling of zero fixes that bound at rps. It returns nil, i.e. no limit, if rps is not positive.
+func NewRateLimiter(rps float64, floor float64, ceiling float64) *RateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	if floor <= 0 || floor > rps {
+		floor = rps
+	}
+	if ceiling <= 0 || ceiling < rps {
+		ceiling = rps
+	}
+	return &RateLimiter{
+		limiter: rate.NewLimiter(rate.Limit(rps), 1),
+		floor:   rate.Limit(floor),
+		ceiling: rate.Limit(ceiling),
+		now:     time.Now,
+	}
+}
+
+// Wait blocks until the next request may be made.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	return l.limiter.Wait(ctx)
+}
+
+// Limit returns the current rate in requests per second, or zero for no limit.
+func (l *RateLimiter) Limit() float64 {
+	if l == nil {
+		return 0
+	}
+	return float64(l.limiter.Limit())
+}
+
+// Observe adapts the rate to the outcome of a request.
+func (l *RateLimiter) Observe(resp *http.Response, latency time.Duration, err error) {
+	l.observe(resp, latency, err)
+}
+
+// observe adapts the rate like Observe, and returns the rates before and after when it changed.
+func (l *RateLimiter) observe(resp *http.Response, latency time.Duration, err error) (from, to float64, adjusted bool) {
+	if l == nil || l.floor == l.ceiling {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current := l.limiter.Limit()
+	switch {
+	case err != nil:
+		return
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		return l.adjust(current * backoffFactor)
+	case resp.StatusCode >= 500:
+		return
+	}
+
+	if l.latency == 0 {
+		l.latency = latency
+	} else {
+		l.latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(l.latency))
+	}
+	if l.baseline == 0 || l.latency < l.baseline {
+		l.baseline = l.latency
+	}
+
+	if float64(l.latency) > latencyFactor*float64(l.baseline) {
+		return l.adjust(current * slowdownFactor)
+	}
+	return l.adjust(current * speedupFactor)
+}
+
+func (l *RateLimiter) adjust(limit rate.Limit) (from, to float64, adjusted bool) {
+	now := l.now()
+	if now.Sub(l.lastAdjust) < adjustInterval {
+		return
+	}
+	if limit < l.floor {
+		limit = l.floor
+	}
+	if limit > l.ceiling {
+		limit = l.ceiling
+	}
+	if limit == l.limiter.Limit() {
+		return
+	}
+	l.lastAdjust = now
+	from = float64(l.limiter.Limit())
+	l.limiter.SetLimitAt(now, limit)
+	return from, float64(limit), true
+}
+
+type rateLimiterKey struct{}
+
+type rateLimiterUse struct {
+	limiter *RateLimiter
+	wait    bool
+}
+
+// withRateLimiter makes requests using ctx wait for limiter, and report their outcome to it.
+func withRateLimiter(ctx context.Context, limiter *RateLimiter) context.Context {
+	return context.WithValue(ctx, rateLimiterKey{}, rateLimiterUse{limiter: limiter, wait: true})
+}
+
+// withRateObserver makes requests using ctx report their outcome to limiter, without waiting for it. This lets the
+// responses of a destination slow down the requests made to a source.
+func withRateObserver(ctx context.Context, limiter *RateLimiter) context.Context {
+	return context.WithValue(ctx, rateLimiterKey{}, rateLimiterUse{limiter: limiter})
+}
+
+// limitTransport applies the rate limiter carried by the request context to every attempt of a request, or the one of
+// the client when the context carries none. Changes of an adaptive rate are logged by the client.
+type limitTransport struct {
+	client *Client
+	next   http.RoundTripper
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	use, ok := req.Context().Value(rateLimiterKey{}).(rateLimiterUse)
+	if !ok || use.limiter == nil {
+		use = rateLimiterUse{limiter: t.client.limiter, wait: true}
+	}
+	if use.limiter == nil {
+		return t.next.RoundTrip(req)
+	}
+	if use.wait {
+		if err := use.limiter.Wait(req.Context()); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+		rateLimit.WithLabelValues(req.URL.Host).Set(use.limiter.Limit())
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if from, to, adjusted := use.limiter.observe(resp, time.Since(start), err); adjusted {
+		t.client.log.Debugf("Adjusting rate limit from %.2f to %.2f requests per second", from, to)
+	}
+	return resp, err
+}
@@ -0,0 +1,111 @@
+package restutil
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestRateLimiter(rps, floor, ceiling float64) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)}
+	l := NewRateLimiter(rps, floor, ceiling)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestRateLimiter_BacksOffOnOverload(t *testing.T) {
+	l, clock := newTestRateLimiter(40, 5, 100)
+
+	clock.advance(adjustInterval)
+	l.Observe(&http.Response{StatusCode: http.StatusTooManyRequests}, time.Millisecond, nil)
+	assert.Equal(t, 20.0, l.Limit())
+
+	// responses to requests made at the old rate only count once
+	l.Observe(&http.Response{StatusCode: http.StatusServiceUnavailable}, time.Millisecond, nil)
+	assert.Equal(t, 20.0, l.Limit())
+
+	for i := 0; i < 5; i++ {
+		clock.advance(adjustInterval)
+		l.Observe(&http.Response{StatusCode: http.StatusServiceUnavailable}, time.Millisecond, nil)
+	}
+	assert.Equal(t, 5.0, l.Limit())
+}
+
+func TestRateLimiter_SpeedsUpWhenHealthy(t *testing.T) {
+	l, clock := newTestRateLimiter(20, 5, 22)
+
+	clock.advance(adjustInterval)
+	l.Observe(&http.Response{StatusCode: http.StatusOK}, 10*time.Millisecond, nil)
+	assert.Equal(t, 21.0, l.Limit())
+
+	for i := 0; i < 5; i++ {
+		clock.advance(adjustInterval)
+		l.Observe(&http.Response{StatusCode: http.StatusOK}, 10*time.Millisecond, nil)
+	}
+	assert.Equal(t, 22.0, l.Limit())
+}
+
+func TestRateLimiter_SlowsDownOnRisingLatency(t *testing.T) {
+	l, clock := newTestRateLimiter(20, 5, 100)
+
+	clock.advance(adjustInterval)
+	l.Observe(&http.Response{StatusCode: http.StatusOK}, 10*time.Millisecond, nil)
+	assert.Equal(t, 21.0, l.Limit())
+
+	for i := 0; i < 10; i++ {
+		l.Observe(&http.Response{StatusCode: http.StatusOK}, time.Second, nil)
+	}
+	clock.advance(adjustInterval)
+	l.Observe(&http.Response{StatusCode: http.StatusOK}, time.Second, nil)
+	assert.InDelta(t, 16.8, l.Limit(), 0.001)
+}
+
+func TestRateLimiter_Fixed(t *testing.T) {
+	l, clock := newTestRateLimiter(20, 0, 0)
+
+	clock.advance(adjustInterval)
+	l.Observe(&http.Response{StatusCode: http.StatusTooManyRequests}, time.Millisecond, nil)
+	assert.Equal(t, 20.0, l.Limit())
+}
+
+func TestRateLimiter_Nil(t *testing.T) {
+	var l *RateLimiter
+	assert.Nil(t, NewRateLimiter(0, 0, 0))
+	assert.NoError(t, l.Wait(context.Background()))
+	l.Observe(&http.Response{StatusCode: http.StatusTooManyRequests}, time.Millisecond, nil)
+	assert.Equal(t, 0.0, l.Limit())
+}
+
+func TestLimitTransport_ObservesResponses(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	l := NewRateLimiter(40, 1, 100)
+	for _, ctx := range []context.Context{withRateLimiter(context.Background(), l), withRateObserver(context.Background(), l)} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := HttpClient.Do(req.WithContext(ctx))
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, 20.0, l.Limit())
+}
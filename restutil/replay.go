@@ -0,0 +1,149 @@
+package restutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"strings"
+)
+
+// ReadFailures reads the entries of a failure report.
+func ReadFailures(r io.Reader) ([]Failure, error) {
+	var failures []Failure
+	dec := json.NewDecoder(r)
+	for {
+		var f Failure
+		if err := dec.Decode(&f); err != nil {
+			if err == io.EOF {
+				return failures, nil
+			}
+			return nil, fmt.Errorf("error reading failure report: %s", err)
+		}
+		failures = append(failures, f)
+	}
+}
+
+// ReadDumpedResources reads the resources written by put-resources --dump-failed as failed PUTs, identified by their
+// idProperty.
+func ReadDumpedResources(r io.Reader, idProperty string) ([]Failure, error) {
+	var failures []Failure
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return failures, nil
+			}
+			return nil, fmt.Errorf("error reading dumped resources: %s", err)
+		}
+		var r resource
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return nil, fmt.Errorf("error reading dumped resources: %s", err)
+		}
+		id, ok := r[idProperty].(string)
+		if !ok {
+			return nil, fmt.Errorf("error reading dumped resources: no %s property in %s", idProperty, raw)
+		}
+		failures = append(failures, Failure{ID: id, Op: opPut, Resource: raw})
+	}
+}
+
+// ReadDumpedIDs reads the IDs written by put-binary-resources --dump-failed as failed copies.
+func ReadDumpedIDs(r io.Reader) ([]Failure, error) {
+	var failures []Failure
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			failures = append(failures, Failure{ID: id, Op: opCopy})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading dumped IDs: %s", err)
+	}
+	return failures, nil
+}
+
+// ReplayFailures runs the failed operations again against the destination of the service with DefaultClient.
+func ReplayFailures(ctx context.Context, service *SyncService, failures []Failure) (*Summary, error) {
+	return DefaultClient.ReplayFailures(ctx, service, failures)
+}
+
+// ReplayFailures runs the failed operations again against the destination of the service: resources recorded with a
+// failed PUT are PUT again, failed copies, creates and updates are copied again from the source, and failed deletes
+// are deleted again. Other operations, such as those of dump-resources or the compares of dry runs, are skipped.
+func (c *Client) ReplayFailures(ctx context.Context, service *SyncService, failures []Failure) (*Summary, error) {
+	defer service.start(ctx, c)()
+	err := service.replay(failures)
+	return service.summary.finish(), err
+}
+
+func (service *SyncService) replay(failures []Failure) error {
+	var puts, copies, deletes []string
+	resources := make(map[string]json.RawMessage)
+	seen := make(map[checkpointEntry]struct{})
+	add := func(ids *[]string, op string, id string) {
+		if _, found := seen[checkpointEntry{Op: op, ID: id}]; !found {
+			seen[checkpointEntry{Op: op, ID: id}] = struct{}{}
+			*ids = append(*ids, id)
+		}
+	}
+
+	for _, f := range failures {
+		switch f.Op {
+		case opPut:
+			if len(f.Resource) == 0 {
+				service.client.log.Warnf("Cannot replay %s of ID=%v without the resource, skipping", f.Op, f.ID)
+				service.summary.addSkipped(1)
+				continue
+			}
+			add(&puts, opPut, f.ID)
+			resources[f.ID] = f.Resource
+		case opCopy, opCreate, opUpdate:
+			add(&copies, opCopy, f.ID)
+		case opDelete:
+			add(&deletes, opDelete, f.ID)
+		default:
+			service.client.log.Warnf("Cannot replay %s of ID=%v, skipping", f.Op, f.ID)
+			service.summary.addSkipped(1)
+		}
+	}
+	if len(copies) > 0 && service.SourceURL == "" {
+		return errors.New("a source URL must be provided to replay copies")
+	}
+
+	journal, err := service.openCheckpoint()
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	rp := &resourcePutter{client: service.client, ctx: service.reqCtx, baseURL: service.DestURL, limiter: service.DestLimiter}
+	if _, err := service.runAll("Done puts", opPut, puts, journal, func(id string) (bool, error) {
+		u, err := generatePutURL(id, rp.baseURL)
+		if err != nil {
+			return false, err
+		}
+		if err := rp.put(u.String(), bytes.NewReader(resources[id]), "application/json"); err != nil {
+			return false, err
+		}
+		service.summary.addWritten(1)
+		return true, nil
+	}); err != nil {
+		return err
+	}
+
+	if _, err := service.runAll("Done copies", opCopy, copies, journal, func(id string) (bool, error) {
+		return true, service.copy(id)
+	}); err != nil {
+		return err
+	}
+
+	_, err = service.runAll("Done deletes", opDelete, deletes, journal, func(id string) (bool, error) {
+		return true, service.delete(id)
+	})
+	return err
+}
@@ -0,0 +1,90 @@
+package restutil
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"strings"
+	"testing"
+)
+
+func TestReplayFailures_RunsRecordedOperations(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{
+		"UUID-3": `{"id":"UUID-3"}`,
+	})
+	defer dest.Close()
+
+	failures, err := ReadFailures(strings.NewReader(`{"id":"UUID-1","op":"copy","error":"error fetching resource: 503 Service Unavailable"}
+{"id":"UUID-2","op":"put","error":"http fail: 500 Internal Server Error","resource":{"id":"UUID-2","name":"foo"}}
+{"id":"UUID-3","op":"delete","error":"error deleting resource: 503 Service Unavailable"}
+{"id":"UUID-1","op":"create","error":"error copying resource: 503 Service Unavailable"}
+{"id":"UUID-4","op":"put","error":"http fail: 500 Internal Server Error"}
+{"id":"UUID-5","op":"get","error":"error fetching resource: 503 Service Unavailable"}
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(failures))
+
+	service := &SyncService{
+		SourceURL:         source.URL,
+		DestURL:           dest.URL,
+		MaxConcurrentReqs: 2,
+	}
+
+	_, err = ReplayFailures(context.Background(), service, failures)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UUID-1", "UUID-2"}, dest.puts())
+	assert.Equal(t, `{"id":"UUID-2","name":"foo"}`, dest.get("UUID-2"))
+	assert.Equal(t, "", dest.get("UUID-3"))
+	assert.Equal(t, 1, len(source.requests("GET")))
+}
+
+func TestReplayFailures_SkipsCompares(t *testing.T) {
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+
+	service := &SyncService{
+		SourceURL:         source.URL,
+		DestURL:           dest.URL,
+		MaxConcurrentReqs: 1,
+	}
+
+	summary, err := ReplayFailures(context.Background(), service, []Failure{{ID: "UUID-1", Op: opCompare}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), summary.Skipped)
+	assert.Empty(t, source.requests("GET"))
+	assert.Empty(t, dest.puts())
+}
+
+func TestReplayFailures_CopiesNeedSource(t *testing.T) {
+	service := &SyncService{DestURL: "http://localhost/bar/", MaxConcurrentReqs: 1}
+	_, err := ReplayFailures(context.Background(), service, []Failure{{ID: "UUID-1", Op: opCopy}})
+	assert.Error(t, err)
+}
+
+func TestReadDumpedResources(t *testing.T) {
+	failures, err := ReadDumpedResources(strings.NewReader(`{"uuid":"UUID-1","name":"foo"}
+{"uuid":"UUID-2"}
+`), "uuid")
+	assert.NoError(t, err)
+	assert.Equal(t, []Failure{
+		{ID: "UUID-1", Op: opPut, Resource: json.RawMessage(`{"uuid":"UUID-1","name":"foo"}`)},
+		{ID: "UUID-2", Op: opPut, Resource: json.RawMessage(`{"uuid":"UUID-2"}`)},
+	}, failures)
+
+	_, err = ReadDumpedResources(strings.NewReader(`{"id":"UUID-1"}`), "uuid")
+	assert.Error(t, err)
+}
+
+func TestReadDumpedIDs(t *testing.T) {
+	failures, err := ReadDumpedIDs(strings.NewReader("UUID-1\n\nUUID-2\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Failure{{ID: "UUID-1", Op: opCopy}, {ID: "UUID-2", Op: opCopy}}, failures)
+}
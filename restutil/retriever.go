@@ -2,9 +2,8 @@ package restutil
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
-	"io"
+	"golang.org/x/net/context"
 	"net/http"
 	"net/url"
 	"os"
@@ -15,11 +14,22 @@ const Useragent = "up-restutil"
 
 var uuidPattern = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
 
+// GetIDListRetriever returns a retriever reading the ids from the file at filePath when given, or from the __ids of the
+// collection at URL with DefaultClient otherwise.
 func GetIDListRetriever(filePath string, URL string) IDListRetriever {
+	return DefaultClient.IDListRetriever(filePath, URL)
+}
+
+// IDListRetriever returns a retriever reading the ids from the file at filePath when given, or from the __ids of the
+// collection at URL with the client otherwise.
+func (c *Client) IDListRetriever(filePath string, URL string) IDListRetriever {
 	if filePath != "" {
 		return newFileBasedIDListRetriever(filePath)
 	}
-	return newURLBasedIDListRetriever(URL, HttpClient)
+	r := newURLBasedIDListRetriever(URL, c.http)
+	r.userAgent = c.userAgent
+	r.idList = c.idLists.idListFor(URL)
+	return r
 }
 
 func newFileBasedIDListRetriever(filePath string) *fileBasedIDListRetriever {
@@ -28,88 +38,111 @@ func newFileBasedIDListRetriever(filePath string) *fileBasedIDListRetriever {
 
 func newURLBasedIDListRetriever(baseURL string, client *http.Client) *urlBasedIDListRetriever {
 	return &urlBasedIDListRetriever{
-		baseURL: baseURL,
-		client:  client}
+		baseURL:   baseURL,
+		client:    client,
+		userAgent: Useragent}
 }
 
 //IDListRetriever is the interface used for retrieving UUIDs from a provided source
 //
 //Retrieve receives 2 unbuffered channels, one for IDs and the other for errors, and populates them accordingly as the
-//function runs.
+//function runs. It closes the channel of IDs once done, and gives up sending when ctx is done.
 type IDListRetriever interface {
-	Retrieve(chan<- string, chan<- error)
+	Retrieve(context.Context, chan<- string, chan<- error)
+}
+
+// sendID sends id to ids, returning false if ctx is done first.
+func sendID(ctx context.Context, ids chan<- string, id string) bool {
+	select {
+	case ids <- id:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sendError sends err to errChan, unless ctx is done first.
+func sendError(ctx context.Context, errChan chan<- error, err error) {
+	select {
+	case errChan <- err:
+	case <-ctx.Done():
+	}
 }
 
 type fileBasedIDListRetriever struct {
 	filePath string
 }
 
-func (r *fileBasedIDListRetriever) Retrieve(ids chan<- string, errChan chan<- error) {
+func (r *fileBasedIDListRetriever) Retrieve(ctx context.Context, ids chan<- string, errChan chan<- error) {
 	defer close(ids)
 	inputFile, err := os.Open(r.filePath)
 	if err != nil {
-		errChan <- fmt.Errorf("ERROR - Failed opening file=%s: %s", r.filePath, err)
+		sendError(ctx, errChan, fmt.Errorf("ERROR - Failed opening file=%s: %s", r.filePath, err))
 		return
 	}
 	defer inputFile.Close()
+	in, err := Decompress(inputFile)
+	if err != nil {
+		sendError(ctx, errChan, fmt.Errorf("ERROR - Failed reading file=%s: %s", r.filePath, err))
+		return
+	}
+	defer in.Close()
 
-	scanner := bufio.NewScanner(inputFile)
+	scanner := bufio.NewScanner(in)
 	for scanner.Scan() {
 		line := scanner.Text()
-		if uuidPattern.MatchString(line) {
-			ids <- line
-		} else {
-			errChan <- fmt.Errorf("ERROR - Found invalid ID=%s in file=%s", line, r.filePath)
+		if !uuidPattern.MatchString(line) {
+			sendError(ctx, errChan, fmt.Errorf("ERROR - Found invalid ID=%s in file=%s", line, r.filePath))
+			return
+		}
+		if !sendID(ctx, ids, line) {
 			return
 		}
 	}
 }
 
 type urlBasedIDListRetriever struct {
-	client  *http.Client
-	baseURL string
+	client    *http.Client
+	baseURL   string
+	userAgent string
+	idList    *IDList
 }
 
-func (r *urlBasedIDListRetriever) Retrieve(ids chan<- string, errChan chan<- error) {
+func (r *urlBasedIDListRetriever) Retrieve(ctx context.Context, ids chan<- string, errChan chan<- error) {
 	defer close(ids)
 	u, err := url.Parse(r.baseURL)
 	if err != nil {
-		errChan <- fmt.Errorf("ERROR - %s", err)
+		sendError(ctx, errChan, fmt.Errorf("ERROR - %s", err))
 		return
 	}
 	u, err = u.Parse("./__ids")
 	if err != nil {
-		errChan <- fmt.Errorf("ERROR - %s", err)
+		sendError(ctx, errChan, fmt.Errorf("ERROR - %s", err))
 		return
 	}
 
-	req, err := http.NewRequest("GET", u.String(), nil)
-	if err != nil {
-		errChan <- fmt.Errorf("ERROR - %s", err)
-		return
-	}
-	req.Header.Set("User-Agent", Useragent)
-	resp, err := r.client.Do(req)
-	if err != nil {
-		errChan <- fmt.Errorf("ERROR - %s", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	type listEntry struct {
-		ID string `json:"id"`
-	}
-
-	var le listEntry
-	dec := json.NewDecoder(resp.Body)
-	for {
-		err = dec.Decode(&le)
+	err = r.idList.listIDs(u, func(pageURL *url.URL) (*http.Response, error) {
+		req, err := http.NewRequest("GET", pageURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", r.userAgent)
+		resp, err := r.client.Do(req.WithContext(ctx))
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			errChan <- fmt.Errorf("ERROR - %s", err)
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected status %s fetching %s", resp.Status, pageURL)
+		}
+		return resp, nil
+	}, func(id string) error {
+		if !sendID(ctx, ids, id) {
+			return ctx.Err()
 		}
-		ids <- le.ID
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		sendError(ctx, errChan, fmt.Errorf("ERROR - %s", err))
 	}
 }
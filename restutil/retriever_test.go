@@ -6,9 +6,11 @@ import (
 	"github.com/h2non/gock"
 	"github.com/nbio/st"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
 	"net/http"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestFileBasedRetrieve_Success(t *testing.T) {
@@ -34,7 +36,7 @@ func TestFileBasedRetrieve_Success(t *testing.T) {
 	var errChan = make(chan error)
 	var actualIds = make(map[string]struct{})
 
-	go retriever.Retrieve(idsChan, errChan)
+	go retriever.Retrieve(context.Background(), idsChan, errChan)
 
 	for idsChan != nil {
 		select {
@@ -64,7 +66,7 @@ func TestFileBasedRetrieve_FileOpenFailure(t *testing.T) {
 	var idsChan = make(chan string)
 	var errChan = make(chan error)
 
-	go retriever.Retrieve(idsChan, errChan)
+	go retriever.Retrieve(context.Background(), idsChan, errChan)
 
 	for idsChan != nil {
 		select {
@@ -121,7 +123,7 @@ func retrieveInvalidID(t *testing.T, invalidID string) {
 	var idsChan = make(chan string)
 	var errChan = make(chan error)
 
-	go retriever.Retrieve(idsChan, errChan)
+	go retriever.Retrieve(context.Background(), idsChan, errChan)
 
 	for idsChan != nil {
 		select {
@@ -154,7 +156,7 @@ func TestUrlBasedRetrieve_Success(t *testing.T) {
 	var errChan = make(chan error)
 	var actualIds = make(map[string]struct{})
 
-	go retriever.Retrieve(idsChan, errChan)
+	go retriever.Retrieve(context.Background(), idsChan, errChan)
 
 	for idsChan != nil {
 		select {
@@ -186,7 +188,7 @@ func TestUrlBasedRetrieve_InvalidUrlFailure(t *testing.T) {
 	var idsChan = make(chan string)
 	var errChan = make(chan error)
 
-	go retriever.Retrieve(idsChan, errChan)
+	go retriever.Retrieve(context.Background(), idsChan, errChan)
 
 	for idsChan != nil {
 		select {
@@ -216,7 +218,7 @@ func TestUrlBasedRetrieve_InvalidResponseFailure(t *testing.T) {
 	var idsChan = make(chan string)
 	var errChan = make(chan error)
 
-	go retriever.Retrieve(idsChan, errChan)
+	go retriever.Retrieve(context.Background(), idsChan, errChan)
 
 	for idsChan != nil {
 		select {
@@ -234,3 +236,25 @@ func TestUrlBasedRetrieve_InvalidResponseFailure(t *testing.T) {
 
 	st.Expect(t, gock.IsDone(), true)
 }
+
+func TestUrlBasedRetrieve_StopsWhenCancelled(t *testing.T) {
+	source := newFakeCollection(map[string]string{"UUID-1": `{}`, "UUID-2": `{}`, "UUID-3": `{}`})
+	defer source.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	retriever := newURLBasedIDListRetriever(source.URL+"/", http.DefaultClient)
+	idsChan := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		retriever.Retrieve(ctx, idsChan, make(chan error))
+		close(done)
+	}()
+
+	assert.Equal(t, "UUID-1", <-idsChan)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Retrieve still blocked after cancelling")
+	}
+}
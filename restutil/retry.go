@@ -0,0 +1,141 @@
+package restutil
+
+import (
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed request is retried.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a request is retried after the first attempt.
+	MaxRetries int
+	// BaseDelay is the delay before the first retry, doubled for each further retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries. A Retry-After header may ask for longer.
+	MaxDelay time.Duration
+	// RetryableStatus lists the response status codes that are retried. Network errors are always retried.
+	RetryableStatus []int
+}
+
+// DefaultRetryPolicy is applied to every request made by a client not given a retry policy of its own.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxRetries:      2,
+	BaseDelay:       time.Second,
+	MaxDelay:        30 * time.Second,
+	RetryableStatus: []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+}
+
+func (p *RetryPolicy) retryable(status int) bool {
+	for _, s := range p.RetryableStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait before the given retry, counting from zero, using exponential backoff with jitter
+// and honouring any Retry-After header of a 429 or 503 response.
+func (p *RetryPolicy) delay(retry int, resp *http.Response) time.Duration {
+	d := p.BaseDelay << uint(retry)
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	if d > 0 {
+		// equal jitter, so that concurrent requests failing together do not retry together
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if after, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok && after > d {
+			d = after
+		}
+	}
+	return d
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+type retryPolicyKey struct{}
+
+// withRetryPolicy makes requests using ctx retry according to policy, rather than the retry policy of the client.
+func withRetryPolicy(ctx context.Context, policy *RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// retryTransport retries requests according to the retry policy carried by the request context, or the one of the
+// client when the context carries none. Requests whose body cannot be sent again are never retried.
+type retryTransport struct {
+	client *Client
+	next   http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy, ok := req.Context().Value(retryPolicyKey{}).(*RetryPolicy)
+	if !ok {
+		policy = t.client.retryPolicy()
+	}
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	attemptReq := req
+	for retry := 0; ; retry++ {
+		countAttempt(req)
+		resp, err := t.next.RoundTrip(attemptReq)
+		if policy == nil || retry >= policy.MaxRetries || !replayable || req.Context().Err() != nil {
+			return resp, err
+		}
+		if err == nil && !policy.retryable(resp.StatusCode) {
+			return resp, err
+		}
+
+		delay := policy.delay(retry, resp)
+		var cause string
+		if err != nil {
+			cause = err.Error()
+		} else {
+			cause = resp.Status
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		t.client.log.Warnf("Retrying %s %s in %v after %s (retry %d of %d)", req.Method, req.URL, delay, cause, retry+1, policy.MaxRetries)
+		atomic.AddInt64(&t.client.transferred.retries, 1)
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+	}
+}
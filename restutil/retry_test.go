@@ -0,0 +1,107 @@
+package restutil
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport_RetriesRetryableStatus(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, RetryableStatus: []int{http.StatusServiceUnavailable}}
+
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+	dest.fail("PUT", "UUID-1", 2)
+
+	rp := &resourcePutter{client: DefaultClient, baseURL: dest.URL}
+	err := rp.put(dest.URL+"/UUID-1", strings.NewReader(`{"id":"UUID-1"}`), "application/json")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(dest.requests("PUT")))
+	assert.Equal(t, `{"id":"UUID-1"}`, dest.get("UUID-1"))
+}
+
+func TestRetryTransport_GivesUpAfterMaxRetries(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, RetryableStatus: []int{http.StatusServiceUnavailable}}
+
+	source := newFakeCollection(map[string]string{"UUID-1": `{"id":"UUID-1"}`})
+	defer source.Close()
+	source.fail("GET", "UUID-1", 2)
+
+	_, err := DefaultClient.doGet(context.Background(), source.URL, "UUID-1")
+	assert.Error(t, err)
+	assert.Equal(t, 2, len(source.requests("GET")))
+}
+
+func TestRetryTransport_DoesNotRetryOtherStatus(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, RetryableStatus: []int{http.StatusBadGateway}}
+
+	source := newFakeCollection(map[string]string{"UUID-1": `{"id":"UUID-1"}`})
+	defer source.Close()
+	source.fail("GET", "UUID-1", 1)
+
+	_, err := DefaultClient.doGet(context.Background(), source.URL, "UUID-1")
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(source.requests("GET")))
+}
+
+func TestRetryTransport_HonoursRetryAfter(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, RetryableStatus: []int{http.StatusTooManyRequests}}
+
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		times = append(times, time.Now())
+		if len(times) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	_, err := DefaultClient.doGet(context.Background(), server.URL, "UUID-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(times))
+	assert.True(t, times[1].Sub(times[0]) >= time.Second, "retried after %v", times[1].Sub(times[0]))
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for retry, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		if max > time.Second {
+			max = time.Second
+		}
+		d := p.delay(retry, nil)
+		assert.True(t, d >= max/2 && d <= max, "retry %d delay %v not in [%v, %v]", retry, d, max/2, max)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"Wed, 01 Jun 2016 12:00:30 GMT", 30 * time.Second, true},
+		{"Wed, 01 Jun 2016 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, test := range tests {
+		d, ok := parseRetryAfter(test.value, now)
+		assert.Equal(t, test.ok, ok, test.value)
+		assert.Equal(t, test.expected, d, test.value)
+	}
+}
@@ -0,0 +1,120 @@
+package restutil
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Summary counts what a command did. Read and Written count resources, and Operations counts the successful
+// operations of each kind, e.g. "create" or "delete". Retried and Bytes count the retries and the bytes of request and
+// response bodies of every request made, including those listing IDs. Duration is in seconds.
+//
+// Counting into a nil *Summary does nothing.
+type Summary struct {
+	Read       int64            `json:"read"`
+	Written    int64            `json:"written"`
+	Skipped    int64            `json:"skipped"`
+	Failed     int64            `json:"failed"`
+	Retried    int64            `json:"retried"`
+	Bytes      int64            `json:"bytes"`
+	Duration   float64          `json:"duration"`
+	Operations map[string]int64 `json:"operations"`
+
+	mu    sync.Mutex
+	start time.Time
+	// the transfers of the client making the requests, and their totals when the command started
+	transferred *transferCounts
+	retried     int64
+	bytes       int64
+}
+
+// newSummary starts a summary of a command making its requests with c.
+func (c *Client) newSummary() *Summary {
+	return &Summary{
+		Operations:  make(map[string]int64),
+		start:       time.Now(),
+		transferred: &c.transferred,
+		retried:     atomic.LoadInt64(&c.transferred.retries),
+		bytes:       atomic.LoadInt64(&c.transferred.bytes),
+	}
+}
+
+// finish records the retries, bytes and time taken since the summary was started, and returns it.
+func (s *Summary) finish() *Summary {
+	s.Retried = atomic.LoadInt64(&s.transferred.retries) - s.retried
+	s.Bytes = atomic.LoadInt64(&s.transferred.bytes) - s.bytes
+	s.Duration = time.Since(s.start).Seconds()
+	return s
+}
+
+func (s *Summary) addRead(n int64) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.Read, n)
+}
+
+func (s *Summary) addWritten(n int64) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.Written, n)
+}
+
+func (s *Summary) addSkipped(n int64) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.Skipped, n)
+}
+
+// addFailed counts a failed operation, also in the metrics.
+func (s *Summary) addFailed(op string) {
+	itemsFailed.WithLabelValues(op).Inc()
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.Failed, 1)
+}
+
+// addOp counts a successful operation, also in the metrics.
+func (s *Summary) addOp(op string) {
+	itemsProcessed.WithLabelValues(op).Inc()
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Operations[op]++
+}
+
+// countTransport counts the body bytes of every request and response into counts.
+type countTransport struct {
+	counts *transferCounts
+	next   http.RoundTripper
+}
+
+func (t *countTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.ContentLength > 0 {
+		atomic.AddInt64(&t.counts.bytes, req.ContentLength)
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		resp.Body = &countingBody{ReadCloser: resp.Body, counts: t.counts}
+	}
+	return resp, err
+}
+
+type countingBody struct {
+	io.ReadCloser
+	counts *transferCounts
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.counts.bytes, int64(n))
+	return n, err
+}
@@ -0,0 +1,80 @@
+package restutil
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSyncIDs_SummaryCountsOperations(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, RetryableStatus: []int{http.StatusServiceUnavailable}}
+
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2","name":"new"}`,
+		"UUID-3": `{"id":"UUID-3"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2","name":"old"}`,
+		"UUID-4": `{"id":"UUID-4"}`,
+	})
+	defer dest.Close()
+	dest.fail("PUT", "UUID-3", 1)
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1", "UUID-2", "UUID-3"},
+		DestIDsRetriever:   staticIDListRetriever{"UUID-1", "UUID-2", "UUID-4"},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+		CompareContent:     true,
+		Deletes:            true,
+	}
+
+	summary, err := SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{opCreate: 1, opUpdate: 1, opDelete: 1}, summary.Operations)
+	assert.Equal(t, int64(5), summary.Read)
+	assert.Equal(t, int64(3), summary.Written)
+	assert.Equal(t, int64(1), summary.Skipped)
+	assert.Equal(t, int64(0), summary.Failed)
+	assert.Equal(t, int64(1), summary.Retried)
+	assert.True(t, summary.Bytes > 0)
+	assert.True(t, summary.Duration > 0)
+}
+
+func TestSyncIDs_SummaryCountsFailures(t *testing.T) {
+	defer func(p *RetryPolicy) { DefaultRetryPolicy = p }(DefaultRetryPolicy)
+	DefaultRetryPolicy = &RetryPolicy{}
+
+	source := newFakeCollection(map[string]string{
+		"UUID-1": `{"id":"UUID-1"}`,
+		"UUID-2": `{"id":"UUID-2"}`,
+	})
+	defer source.Close()
+	dest := newFakeCollection(map[string]string{})
+	defer dest.Close()
+	dest.fail("PUT", "UUID-1", 1)
+
+	service := &SyncService{
+		SourceIDsRetriever: staticIDListRetriever{"UUID-1", "UUID-2"},
+		DestIDsRetriever:   staticIDListRetriever{},
+		SourceURL:          source.URL,
+		DestURL:            dest.URL,
+		MaxConcurrentReqs:  1,
+		Failures:           &FailureReport{enc: json.NewEncoder(ioutil.Discard)},
+	}
+
+	summary, err := SyncIDs(context.Background(), service)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{opCreate: 1}, summary.Operations)
+	assert.Equal(t, int64(1), summary.Failed)
+	assert.Equal(t, int64(1), summary.Written)
+}